@@ -1,10 +1,11 @@
 package cache
 
 import (
+	"context"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/sync/singleflight"
 
 	"github.com/baetyl/baetyl-go/v2/cache/persist"
 )
@@ -16,8 +17,22 @@ type Strategy struct {
 	// CacheStore if nil, use default cache store instead
 	CacheStore persist.CacheStore
 
+	// CacheStoreName the store label of CacheStore in statistics, the store is not counted if empty, see WithStats
+	CacheStoreName string
+
 	// CacheDuration
 	CacheDuration time.Duration
+
+	// StaleWhileRevalidate if greater than zero, overrides the default stale-while-revalidate window,
+	// it is ignored with an error logged by the gin middleware, see WithStaleWhileRevalidate
+	StaleWhileRevalidate time.Duration
+
+	// StaleIfError if greater than zero, overrides the default stale-if-error window
+	StaleIfError time.Duration
+
+	// Tags attached to the cache, only takes effect when the cache store implements persist.InvalidatableStore,
+	// then all responses with a tag can be invalidated by DeleteByTag
+	Tags []string
 }
 
 // GetCacheStrategyByRequest User can this function to design custom cache strategy by request.
@@ -30,99 +45,125 @@ func _cache(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, c
 		panic("cache strategy is nil")
 	}
 
-	sfGroup := singleflight.Group{}
+	// a middleware can not re-run the rest of the chain in background, so the stale response is never replied
+	// while revalidating, otherwise the refreshed response would skip the intermediate handlers
+	core := newCacheCore(defaultCacheStore, defaultExpire, cfg, !isMiddleware)
 
 	return func(c *gin.Context) {
-		cacheStrategy, shouldCache := cfg.getCacheStrategyByRequest(c)
-		if !shouldCache {
-			if isMiddleware {
-				c.Next()
-			} else {
-				handle(c)
-			}
-			return
-		}
-
-		cacheKey := cacheStrategy.CacheKey
-
-		if cfg.prefixKey != "" {
-			cacheKey = cfg.prefixKey + cacheKey
-		}
-
-		if cfg.keyWithGinContext != nil && len(cfg.keyWithGinContext) > 0 {
-			for _, k := range cfg.keyWithGinContext {
-				cacheKey = c.GetString(k) + cacheKey
-			}
-		}
-
-		// merge cfg
-		cacheStore := defaultCacheStore
-		if cacheStrategy.CacheStore != nil {
-			cacheStore = cacheStrategy.CacheStore
-		}
-
-		cacheDuration := defaultExpire
-		if cacheStrategy.CacheDuration > 0 {
-			cacheDuration = cacheStrategy.CacheDuration
-		}
-
-		// read cache first
-		{
-			respCache := &ResponseCache{}
-			err := cacheStore.Get(cacheKey, &respCache)
-			if err == nil {
-				replyWithCache(c, cfg, respCache)
-				cfg.hitCacheCallback(c)
-				return
-			}
-
-			if err != persist.ErrCacheMiss {
-				cfg.logger.Errorf("get cache error: %s, cache key: %s", err, cacheKey)
-			}
-			cfg.missCacheCallback(c)
-		}
-
-		// cache miss, then call the backend
-
-		// use responseCacheWriter in order to record the response
-		cacheWriter := &responseCacheWriter{
-			ResponseWriter: c.Writer,
-		}
-		c.Writer = cacheWriter
-
-		inFlight := false
-		rawRespCache, _, _ := sfGroup.Do(cacheKey, func() (interface{}, error) {
-			if cfg.singleFlightForgetTimeout > 0 {
-				forgetTimer := time.AfterFunc(cfg.singleFlightForgetTimeout, func() {
-					sfGroup.Forget(cacheKey)
-				})
-				defer forgetTimer.Stop()
-			}
-
-			if isMiddleware {
-				c.Next()
-			} else {
-				handle(c)
-			}
-
-			inFlight = true
-
-			respCache := &ResponseCache{}
-			respCache.fillWithCacheWriter(cacheWriter, cfg.withoutHeader, cfg.withoutHeaderIgnore)
-
-			// only cache 2xx response
-			if !c.IsAborted() && cacheWriter.Status() < 300 && cacheWriter.Status() >= 200 {
-				if err := cacheStore.Set(cacheKey, respCache, cacheDuration); err != nil {
-					cfg.logger.Errorf("set cache key error: %s, cache key: %s", err, cacheKey)
-				}
-			}
-
-			return respCache, nil
-		})
-
-		if !inFlight {
-			replyWithCache(c, cfg, rawRespCache.(*ResponseCache))
-			cfg.shareSingleFlightCallback(c)
-		}
+		core.serve(&ginContext{c: c, cfg: cfg, isMiddleware: isMiddleware, handle: handle})
+	}
+}
+
+// ginContext adapts the gin context to the cache pipeline
+type ginContext struct {
+	c            *gin.Context
+	cfg          *Config
+	isMiddleware bool
+	handle       gin.HandlerFunc
+	// detached the copied context refreshing in background, which is always aborted
+	detached bool
+	writer   *responseCacheWriter
+}
+
+func (a *ginContext) strategy() (Strategy, bool) {
+	return a.cfg.getCacheStrategyByRequest(a.c)
+}
+
+func (a *ginContext) value(key string) string {
+	return a.c.GetString(key)
+}
+
+func (a *ginContext) request() *http.Request {
+	return a.c.Request
+}
+
+func (a *ginContext) route() string {
+	if route := a.c.FullPath(); route != "" {
+		return route
+	}
+	return statsOtherRoute
+}
+
+func (a *ginContext) warming() bool {
+	return isWarming(a.c.Request.Context())
+}
+
+func (a *ginContext) next() error {
+	if a.isMiddleware {
+		a.c.Next()
+	} else {
+		a.handle(a.c)
+	}
+	return nil
+}
+
+func (a *ginContext) record() {
+	// use responseCacheWriter in order to record the response
+	a.writer = &responseCacheWriter{
+		ResponseWriter: a.c.Writer,
+		buffered:       true,
+	}
+	a.c.Writer = a.writer
+}
+
+func (a *ginContext) run() error {
+	return a.next()
+}
+
+func (a *ginContext) response() (int, http.Header, []byte) {
+	return a.writer.Status(), a.writer.Header(), a.writer.body.Bytes()
+}
+
+func (a *ginContext) aborted() bool {
+	return !a.detached && a.c.IsAborted()
+}
+
+func (a *ginContext) validate(etag string, lastModified time.Time) {
+	a.writer.Header().Set(headerETag, etag)
+	a.writer.Header().Set(headerLastModified, lastModified.Format(http.TimeFormat))
+}
+
+func (a *ginContext) discard() {
+	a.writer.buffered = false
+	for k := range a.writer.Header() {
+		delete(a.writer.Header(), k)
+	}
+}
+
+func (a *ginContext) flush() error {
+	return a.writer.flush()
+}
+
+func (a *ginContext) reply(respCache *ResponseCache) {
+	if a.writer != nil {
+		a.writer.buffered = false
 	}
+	replyWithCache(a.c, a.cfg, respCache)
+}
+
+func (a *ginContext) callback(kind statsKind) {
+	switch kind {
+	case statsHit:
+		a.cfg.hitCacheCallback(a.c)
+	case statsMiss:
+		a.cfg.missCacheCallback(a.c)
+	case statsStaleHit:
+		a.cfg.hitStaleCacheCallback(a.c)
+	case statsStaleIfError:
+		a.cfg.staleIfErrorCallback(a.c)
+	case statsSharedFlight:
+		a.cfg.shareSingleFlightCallback(a.c)
+	}
+}
+
+func (a *ginContext) refresh(fetch func(c cacheContext), done func()) {
+	cp := a.c.Copy()
+	cp.Request = a.c.Request.WithContext(context.Background())
+	cp.Writer = newDetachedWriter()
+	go func() {
+		defer done()
+		bg := &ginContext{c: cp, cfg: a.cfg, isMiddleware: a.isMiddleware, handle: a.handle, detached: true}
+		bg.record()
+		fetch(bg)
+	}()
 }
@@ -10,7 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -368,3 +370,434 @@ func TestWrapper(t *testing.T) {
 	w4 := mockWrapperHttpRequest(cacheURIWrapper, requestPath, true)
 	assert.NotEqual(t, w3.Body, w4.Body)
 }
+
+func TestStrategyTags(t *testing.T) {
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	cacheTagMiddleware := MCache(memoryStore, 3*time.Second, WithCacheStrategyByRequest(func(c *gin.Context) (Strategy, bool) {
+		return Strategy{
+			CacheKey: c.Request.RequestURI,
+			Tags:     []string{"uid:" + c.Query("uid")},
+		}, true
+	}))
+
+	w1 := mockHttpRequest(cacheTagMiddleware, "/cache?uid=u1&a=1", true)
+	w2 := mockHttpRequest(cacheTagMiddleware, "/cache?uid=u2", true)
+
+	require.NoError(t, memoryStore.DeleteByTag("uid:u1"))
+
+	w3 := mockHttpRequest(cacheTagMiddleware, "/cache?uid=u1&a=1", true)
+	w4 := mockHttpRequest(cacheTagMiddleware, "/cache?uid=u2", true)
+	assert.NotEqual(t, w1.Body, w3.Body)
+	assert.Equal(t, w2.Body, w4.Body)
+}
+
+func TestConditionalRequest(t *testing.T) {
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	cacheURIWrapper := func(handlerFunc gin.HandlerFunc) gin.HandlerFunc {
+		return WCacheByRequestURI(memoryStore, 3*time.Second, handlerFunc, WithoutHeader())
+	}
+
+	testWriter := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testWriter)
+	engine.GET("/cache", cacheURIWrapper(func(c *gin.Context) {
+		c.String(http.StatusOK, "value")
+	}))
+
+	serve := func(header, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/cache", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// the first response has the validators
+	w1 := serve("", "")
+	assert.Equal(t, http.StatusOK, w1.Code)
+	assert.Equal(t, "value", w1.Body.String())
+	etag := w1.Header().Get(headerETag)
+	lastModified := w1.Header().Get(headerLastModified)
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	w2 := serve("", "")
+	assert.Equal(t, http.StatusOK, w2.Code)
+	assert.Equal(t, etag, w2.Header().Get(headerETag))
+	assert.Equal(t, lastModified, w2.Header().Get(headerLastModified))
+	assert.Equal(t, "value", w2.Body.String())
+
+	w3 := serve(headerIfNoneMatch, `"other", W/`+etag)
+	assert.Equal(t, http.StatusNotModified, w3.Code)
+	assert.Empty(t, w3.Body.String())
+	assert.Equal(t, etag, w3.Header().Get(headerETag))
+
+	w4 := serve(headerIfNoneMatch, `"other"`)
+	assert.Equal(t, http.StatusOK, w4.Code)
+	assert.Equal(t, "value", w4.Body.String())
+
+	w5 := serve(headerIfModifiedSince, lastModified)
+	assert.Equal(t, http.StatusNotModified, w5.Code)
+
+	w6 := serve(headerIfModifiedSince, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	assert.Equal(t, http.StatusOK, w6.Code)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var staleCount int32
+	var counter int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	cacheURIWrapper := WCacheByRequestURI(memoryStore, 1*time.Second, func(c *gin.Context) {
+		time.Sleep(100 * time.Millisecond)
+		c.String(http.StatusOK, "value:%d", atomic.AddInt32(&counter, 1))
+	},
+		WithStaleWhileRevalidate(2*time.Second),
+		WithOnHitStaleCache(func(c *gin.Context) {
+			atomic.AddInt32(&staleCount, 1)
+		}),
+	)
+
+	testWriter := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testWriter)
+	engine.GET("/cache", cacheURIWrapper)
+	serve := func() string {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "value:1", serve())
+	time.Sleep(1100 * time.Millisecond)
+
+	// stale response is replied at once and refreshed in background
+	start := time.Now()
+	assert.Equal(t, "value:1", serve())
+	assert.Equal(t, "value:1", serve())
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&staleCount))
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "value:2", serve())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&counter))
+
+	// out of the window
+	time.Sleep(3100 * time.Millisecond)
+	assert.Equal(t, "value:3", serve())
+}
+
+func TestStaleWhileRevalidateMiddleware(t *testing.T) {
+	var staleCount int32
+	var counter int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	// the option is rejected
+	assert.Panics(t, func() {
+		MCacheByRequestURI(memoryStore, 1*time.Second, WithStaleWhileRevalidate(2*time.Second))
+	})
+	assert.Panics(t, func() {
+		MCacheByRequestPath(memoryStore, 1*time.Second, WithStaleWhileRevalidate(2*time.Second))
+	})
+
+	cacheURIMiddleware := MCache(memoryStore, 1*time.Second,
+		WithCacheStrategyByRequest(func(c *gin.Context) (Strategy, bool) {
+			return Strategy{CacheKey: c.Request.RequestURI, StaleWhileRevalidate: 2 * time.Second}, true
+		}),
+		WithOnHitStaleCache(func(c *gin.Context) {
+			atomic.AddInt32(&staleCount, 1)
+		}),
+	)
+
+	testWriter := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testWriter)
+	engine.Use(cacheURIMiddleware)
+	// the intermediate middleware is part of the cached response
+	engine.Use(func(c *gin.Context) {
+		c.Header(headerOtherKey, fmt.Sprintf("%d", atomic.LoadInt32(&counter)+1))
+		c.Next()
+	})
+	engine.GET("/cache", func(c *gin.Context) {
+		c.String(http.StatusOK, "value:%d", atomic.AddInt32(&counter, 1))
+	})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		return w
+	}
+
+	w := serve()
+	assert.Equal(t, "value:1", w.Body.String())
+	assert.Equal(t, "1", w.Header().Get(headerOtherKey))
+	time.Sleep(1100 * time.Millisecond)
+
+	// the middleware ignores the window of the strategy, and calls the whole chain
+	w = serve()
+	assert.Equal(t, "value:2", w.Body.String())
+	assert.Equal(t, "2", w.Header().Get(headerOtherKey))
+	w = serve()
+	assert.Equal(t, "value:2", w.Body.String())
+	assert.Equal(t, "2", w.Header().Get(headerOtherKey))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&staleCount))
+}
+
+func TestStaleIfError(t *testing.T) {
+	var staleCount int32
+	var fail int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	cacheURIWrapper := WCacheByRequestURI(memoryStore, 1*time.Second, func(c *gin.Context) {
+		if atomic.LoadInt32(&fail) == 1 {
+			c.Header(headerOtherKey, headerOtherVal)
+			c.String(http.StatusInternalServerError, "error")
+			return
+		}
+		c.String(http.StatusOK, "value")
+	}, WithStaleIfError(2*time.Second), WithOnStaleIfError(func(c *gin.Context) {
+		atomic.AddInt32(&staleCount, 1)
+	}))
+
+	testWriter := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testWriter)
+	engine.GET("/cache", cacheURIWrapper)
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		return w
+	}
+
+	assert.Equal(t, "value", serve().Body.String())
+	atomic.StoreInt32(&fail, 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	w := serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "value", w.Body.String())
+	assert.Empty(t, w.Header().Get(headerOtherKey))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&staleCount))
+
+	// the backend recovers
+	atomic.StoreInt32(&fail, 0)
+	w = serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "value", w.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&staleCount))
+
+	// out of the window
+	atomic.StoreInt32(&fail, 1)
+	time.Sleep(3100 * time.Millisecond)
+	w = serve()
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "error", w.Body.String())
+}
+
+func TestDistributedSingleFlight(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	redisStore := persist.NewRedisStore(client)
+	locker := persist.NewRedisLocker(client)
+
+	var calls, shared int32
+	handle := func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		c.String(http.StatusOK, "value")
+	}
+	// two replicas with their own singleflight groups
+	var engines []*gin.Engine
+	for i := 0; i < 2; i++ {
+		wrapper := WCacheByRequestURI(redisStore, 10*time.Second, handle,
+			WithDistributedSingleFlight(locker, 5*time.Second),
+			WithOnShareSingleFlight(func(c *gin.Context) {
+				atomic.AddInt32(&shared, 1)
+			}))
+		_, engine := gin.CreateTestContext(httptest.NewRecorder())
+		engine.GET("/cache", wrapper)
+		engines = append(engines, engine)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(engine *gin.Engine) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+			assert.Equal(t, "value", w.Body.String())
+		}(engines[i%2])
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(9), atomic.LoadInt32(&shared))
+	// the lease is released
+	assert.False(t, mr.Exists("cache:lock:/cache"))
+}
+
+func TestDistributedSingleFlightSlowBackend(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	redisStore := persist.NewRedisStore(client)
+	locker := persist.NewRedisLocker(client)
+
+	// miniredis expires the keys by the simulated time only
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				mr.FastForward(10 * time.Millisecond)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// the backend is slower than the lease
+	var calls int32
+	handle := func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(500 * time.Millisecond)
+		c.String(http.StatusOK, "value")
+	}
+	var engines []*gin.Engine
+	for i := 0; i < 2; i++ {
+		wrapper := WCacheByRequestURI(redisStore, 10*time.Second, handle, WithDistributedSingleFlight(locker, 150*time.Millisecond))
+		_, engine := gin.CreateTestContext(httptest.NewRecorder())
+		engine.GET("/cache", wrapper)
+		engines = append(engines, engine)
+	}
+
+	wg := sync.WaitGroup{}
+	for i, engine := range engines {
+		wg.Add(1)
+		go func(engine *gin.Engine) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+			assert.Equal(t, "value", w.Body.String())
+		}(engine)
+		if i == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	// the lease is renewed, so the other replica waits for the cache
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.False(t, mr.Exists("cache:lock:/cache"))
+}
+
+func TestRespectCacheControl(t *testing.T) {
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	cacheURIMiddleware := MCacheByRequestURI(memoryStore, 3*time.Second, RespectCacheControl())
+
+	var counter int32
+	testWriter := httptest.NewRecorder()
+	_, engine := gin.CreateTestContext(testWriter)
+	engine.Use(cacheURIMiddleware)
+	engine.GET("/cache", func(c *gin.Context) {
+		if cc := c.Query("cc"); cc != "" {
+			c.Header(headerCacheControl, cc)
+		}
+		if vary := c.Query("vary"); vary != "" {
+			c.Header(headerVary, vary)
+		}
+		c.String(http.StatusOK, "%s:%d", c.GetHeader("Authorization"), atomic.AddInt32(&counter, 1))
+	})
+	serve := func(url string, header map[string]string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// request directives
+	assert.Equal(t, ":1", serve("/cache", nil))
+	assert.Equal(t, ":1", serve("/cache", nil))
+	assert.Equal(t, ":2", serve("/cache", map[string]string{headerCacheControl: "no-store"}))
+	assert.Equal(t, ":1", serve("/cache", nil))
+	assert.Equal(t, ":3", serve("/cache", map[string]string{headerCacheControl: "no-cache"}))
+	assert.Equal(t, ":3", serve("/cache", nil))
+	assert.Equal(t, ":4", serve("/cache", map[string]string{headerPragma: "no-cache"}))
+	assert.Equal(t, ":4", serve("/cache", map[string]string{headerCacheControl: "max-age=10"}))
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, ":5", serve("/cache", map[string]string{headerCacheControl: "max-age=1"}))
+
+	// response directives
+	assert.Equal(t, ":6", serve("/cache?cc=no-store", nil))
+	assert.Equal(t, ":7", serve("/cache?cc=no-store", nil))
+	assert.Equal(t, ":8", serve("/cache?cc=private,max-age=60", nil))
+	assert.Equal(t, ":9", serve("/cache?cc=private,max-age=60", nil))
+	assert.Equal(t, ":10", serve("/cache?cc=public,max-age=1", nil))
+	assert.Equal(t, ":10", serve("/cache?cc=public,max-age=1", nil))
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, ":11", serve("/cache?cc=public,max-age=1", nil))
+	assert.Equal(t, ":12", serve("/cache?cc=max-age=60,s-maxage=0", nil))
+	assert.Equal(t, ":13", serve("/cache?cc=max-age=60,s-maxage=0", nil))
+
+	// vary
+	alice := map[string]string{"Authorization": "alice"}
+	bob := map[string]string{"Authorization": "bob"}
+	assert.Equal(t, "alice:14", serve("/cache?vary=authorization", alice))
+	assert.Equal(t, "bob:15", serve("/cache?vary=authorization", bob))
+	assert.Equal(t, "alice:14", serve("/cache?vary=authorization", alice))
+	assert.Equal(t, "bob:15", serve("/cache?vary=authorization", bob))
+	assert.Equal(t, "alice:16", serve("/cache?vary=*", alice))
+	assert.Equal(t, "alice:17", serve("/cache?vary=*", alice))
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(http.Header{headerCacheControl: []string{`public, max-age="30"`, "S-MAXAGE=10"}})
+	assert.Equal(t, 30*time.Second, cc.maxAge)
+	assert.Equal(t, 10*time.Second, cc.sMaxAge)
+	assert.Equal(t, 10*time.Second, cc.ttl(time.Minute))
+
+	cc = parseCacheControl(http.Header{})
+	assert.Equal(t, time.Minute, cc.ttl(time.Minute))
+
+	vary, star := parseVary(http.Header{headerVary: []string{"accept-encoding, Authorization", "Accept-Encoding"}})
+	assert.False(t, star)
+	assert.Equal(t, []string{"Accept-Encoding", "Authorization"}, vary)
+}
+
+func TestResponseCacheSerializer(t *testing.T) {
+	src := &ResponseCache{
+		Status:       http.StatusOK,
+		Header:       http.Header{headerContentTypeKey: []string{headerContentTypeVal}, "Multi": []string{"a", "b"}},
+		Data:         []byte("value"),
+		ETag:         `"etag"`,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+		Date:         time.Now(),
+		Vary:         []string{"Authorization"},
+		VaryKey:      "key",
+	}
+
+	for _, c := range []persist.Codec{persist.GobCodec, persist.JSONCodec, persist.MsgpackCodec, persist.ProtobufCodec} {
+		memoryStore := persist.NewBoundedMemoryStore(time.Minute, 0, persist.EvictLRU)
+		memoryStore.Serializer = persist.NewSerializer(c, persist.CompressionSnappy, 0)
+		require.NoError(t, memoryStore.Set("key", src, 0))
+
+		dest := &ResponseCache{}
+		require.NoError(t, memoryStore.Get("key", &dest))
+		assert.Equal(t, src.Status, dest.Status)
+		assert.Equal(t, src.Header, dest.Header)
+		assert.Equal(t, src.Data, dest.Data)
+		assert.Equal(t, src.ETag, dest.ETag)
+		assert.True(t, src.LastModified.Equal(dest.LastModified))
+		assert.True(t, src.Date.Equal(dest.Date))
+		assert.True(t, dest.ExpireAt.IsZero())
+		assert.Equal(t, src.Vary, dest.Vary)
+		assert.Equal(t, src.VaryKey, dest.VaryKey)
+	}
+
+	memoryStore := persist.NewBoundedMemoryStore(time.Minute, 0, persist.EvictLRU)
+	memoryStore.Serializer = persist.NewSerializer(persist.ProtobufCodec, persist.CompressionGzip, 0)
+	cacheURIMiddleware := MCacheByRequestURI(memoryStore, 3*time.Second)
+	w1 := mockHttpRequest(cacheURIMiddleware, "/cache?uid=u1", true)
+	w2 := mockHttpRequest(cacheURIMiddleware, "/cache?uid=u1", true)
+	assert.Equal(t, w1.Body, w2.Body)
+}
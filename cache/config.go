@@ -1,14 +1,21 @@
 package cache
 
 import (
+	"net/http"
 	"net/url"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	routing "github.com/qiangxue/fasthttp-routing"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
 )
 
+// lockPollInterval the interval to check the cache while another process holds the lease
+const lockPollInterval = 20 * time.Millisecond
+
 // Config contains all options
 type Config struct {
 	logger Logger
@@ -18,13 +25,27 @@ type Config struct {
 	hitCacheCallback  OnHitCacheCallback
 	missCacheCallback OnMissCacheCallback
 
+	staleWhileRevalidate  time.Duration
+	staleIfError          time.Duration
+	hitStaleCacheCallback OnHitStaleCacheCallback
+	staleIfErrorCallback  OnStaleIfErrorCallback
+
 	beforeReplyWithCacheCallback BeforeReplyWithCacheCallback
 
 	singleFlightForgetTimeout time.Duration
 	shareSingleFlightCallback OnShareSingleFlightCallback
 
+	locker    persist.Locker
+	lockLease time.Duration
+
+	stats      *Stats
+	statsRoute string
+	statsStore string
+
 	ignoreQueryOrder bool
 
+	respectCacheControl bool
+
 	withoutHeader bool
 	// Only effective when without is true, including header fields that will not be ignored
 	// Keys that are not in the array will still be ignored
@@ -32,6 +53,10 @@ type Config struct {
 
 	prefixKey         string
 	keyWithGinContext []string
+
+	// the counterparts for fasthttp-routing
+	getRoutingCacheStrategyByRequest GetRoutingCacheStrategyByRequest
+	routingCallbacks                 RoutingCallbacks
 }
 
 func newConfigByOpts(opts ...Option) *Config {
@@ -39,6 +64,8 @@ func newConfigByOpts(opts ...Option) *Config {
 		logger:                       Discard{},
 		hitCacheCallback:             defaultHitCacheCallback,
 		missCacheCallback:            defaultMissCacheCallback,
+		hitStaleCacheCallback:        defaultHitStaleCacheCallback,
+		staleIfErrorCallback:         defaultStaleIfErrorCallback,
 		beforeReplyWithCacheCallback: defaultBeforeReplyWithCacheCallback,
 		shareSingleFlightCallback:    defaultShareSingleFlightCallback,
 	}
@@ -75,6 +102,24 @@ func (cfg *Config) setRequestURI() {
 	cfg.getCacheStrategyByRequest = cacheStrategy
 }
 
+func (cfg *Config) setRoutingRequestURI() {
+	cfg.getRoutingCacheStrategyByRequest = func(c *routing.Context) (Strategy, bool) {
+		uri := string(c.RequestURI())
+		if cfg.ignoreQueryOrder {
+			newUri, err := getRequestUriIgnoreQueryOrder(uri)
+			if err != nil {
+				cfg.logger.Errorf("getRequestUriIgnoreQueryOrder error: %s", err)
+				newUri = uri
+			}
+			uri = newUri
+		}
+
+		return Strategy{
+			CacheKey: uri,
+		}, true
+	}
+}
+
 func getRequestUriIgnoreQueryOrder(requestURI string) (string, error) {
 	parsedUrl, err := url.ParseRequestURI(requestURI)
 	if err != nil {
@@ -103,3 +148,78 @@ func getRequestUriIgnoreQueryOrder(requestURI string) (string, error) {
 
 	return parsedUrl.Path + "?" + strings.Join(queryVals, "&"), nil
 }
+
+// lockFlight acquires the distributed lease of the flight key. If another process holds the lease,
+// it waits for the cache set by that process, and returns the cache.
+// The lease is renewed until unlock is called, so it does not expire while the backend is slow.
+// The returned unlock is nil if the lease is not acquired.
+func (cfg *Config) lockFlight(cacheStore persist.CacheStore, cacheKey, flightKey string, req *http.Request) (func(), *ResponseCache) {
+	// without forget timeout, it waits as long as the lease is held, which expires if the holder crashes
+	var deadline time.Time
+	if cfg.singleFlightForgetTimeout > 0 {
+		deadline = time.Now().Add(cfg.singleFlightForgetTimeout)
+	}
+	for {
+		token, ok, err := cfg.locker.Lock(flightKey, cfg.lockLease)
+		if err != nil {
+			// fall back to the local singleflight
+			cfg.logger.Errorf("lock cache key error: %s, cache key: %s", err, flightKey)
+			return nil, nil
+		}
+		unlock := func() {
+			if err := cfg.locker.Unlock(flightKey, token); err != nil {
+				cfg.logger.Errorf("unlock cache key error: %s, cache key: %s", err, flightKey)
+			}
+		}
+
+		// the cache may be set by the previous holder
+		if respCache, _, err := getCache(cacheStore, cacheKey, req); err == nil && respCache.isFresh(time.Now()) {
+			if ok {
+				unlock()
+			}
+			return nil, respCache
+		}
+		if ok {
+			stop := cfg.renewLease(flightKey, token)
+			return func() {
+				stop()
+				unlock()
+			}, nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, nil
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// renewLease renews the lease of the flight key every third of the lease in background, until stop is called
+func (cfg *Config) renewLease(flightKey, token string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(cfg.lockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ok, err := cfg.locker.Extend(flightKey, token, cfg.lockLease)
+				if err != nil {
+					cfg.logger.Errorf("extend lock of cache key error: %s, cache key: %s", err, flightKey)
+					continue
+				}
+				if !ok {
+					cfg.logger.Errorf("lock of cache key is lost, cache key: %s", flightKey)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerCacheControl = "Cache-Control"
+	headerPragma       = "Pragma"
+	headerVary         = "Vary"
+
+	varyKeySeparator = "#vary#"
+)
+
+// cacheControl the directives of Cache-Control header, which are used by the cache middleware
+type cacheControl struct {
+	noStore bool
+	noCache bool
+	private bool
+	// maxAge and sMaxAge are negative if absent
+	maxAge  time.Duration
+	sMaxAge time.Duration
+}
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{
+		maxAge:  -1,
+		sMaxAge: -1,
+	}
+	values := header.Values(headerCacheControl)
+	if len(values) == 0 {
+		// HTTP/1.0 compatibility
+		cc.noCache = strings.EqualFold(strings.TrimSpace(header.Get(headerPragma)), "no-cache")
+		return cc
+	}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store":
+				cc.noStore = true
+			case "no-cache":
+				cc.noCache = true
+			case "private":
+				cc.private = true
+			case "max-age":
+				cc.maxAge = parseDeltaSeconds(arg)
+			case "s-maxage":
+				cc.sMaxAge = parseDeltaSeconds(arg)
+			}
+		}
+	}
+	return cc
+}
+
+func parseDeltaSeconds(arg string) time.Duration {
+	seconds, err := strconv.ParseInt(strings.Trim(arg, `"`), 10, 64)
+	if err != nil || seconds < 0 {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ttl returns the duration which the response can be cached for by a shared cache, zero means not cacheable
+func (cc cacheControl) ttl(defaultTTL time.Duration) time.Duration {
+	switch {
+	case cc.noStore || cc.noCache || cc.private:
+		return 0
+	case cc.sMaxAge >= 0:
+		return cc.sMaxAge
+	case cc.maxAge >= 0:
+		return cc.maxAge
+	default:
+		return defaultTTL
+	}
+}
+
+// parseVary returns the canonical request header names listed in Vary header, the bool is true if Vary is "*"
+func parseVary(header http.Header) ([]string, bool) {
+	var names []string
+	seen := map[string]struct{}{}
+	for _, value := range header.Values(headerVary) {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, true
+			}
+			name = http.CanonicalHeaderKey(name)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, false
+}
+
+// getVaryKey folds the values of vary request headers into the cache key
+func getVaryKey(cacheKey string, vary []string, req *http.Request) string {
+	values := url.Values{}
+	for _, name := range vary {
+		values[name] = req.Header.Values(name)
+	}
+	return cacheKey + varyKeySeparator + values.Encode()
+}
@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
+)
+
+// cacheContext adapts the request and response of a web framework to the cache pipeline,
+// which is shared by the gin and fasthttp-routing handlers
+type cacheContext interface {
+	// strategy returns the cache strategy of the request, the second return value means whether to cache
+	strategy() (Strategy, bool)
+	// value returns the string set in the context by the previous handlers, see KeyWithGinContext
+	value(key string) string
+	// request returns the method and headers of the request, which the helpers of vary,
+	// conditional request and cache control work on
+	request() *http.Request
+	// route returns the route label of the stats if it is not configured, the values must be bounded
+	route() string
+	// warming returns whether the request is replayed by the warmer
+	warming() bool
+
+	// next calls the backend without caching
+	next() error
+	// record starts recording the response of the backend, which is held back from the client until flush
+	record()
+	// run calls the backend, the error is returned to the framework
+	run() error
+	// response returns the recorded response
+	response() (status int, header http.Header, body []byte)
+	// aborted returns whether the backend aborted the request, then the response is not cached
+	aborted() bool
+	// validate sets the validators of the cached response on the recorded response
+	validate(etag string, lastModified time.Time)
+	// discard drops the recorded response held back from the client
+	discard()
+	// flush sends the recorded response held back from the client
+	flush() error
+	// reply replies with the cached response
+	reply(respCache *ResponseCache)
+	// callback calls the callback of the event
+	callback(kind statsKind)
+	// refresh calls the backend in background detached from the request, by fetch or by replaying the request,
+	// done must be called when it finishes
+	refresh(fetch func(c cacheContext), done func())
+}
+
+// cacheCore the cache pipeline of a handler
+type cacheCore struct {
+	defaultCacheStore persist.CacheStore
+	defaultExpire     time.Duration
+	cfg               *Config
+	// revalidate whether the stale response is replied while refreshing it in background
+	revalidate bool
+
+	sfGroup    singleflight.Group
+	refreshing sync.Map
+}
+
+func newCacheCore(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, cfg *Config, revalidate bool) *cacheCore {
+	return &cacheCore{
+		defaultCacheStore: defaultCacheStore,
+		defaultExpire:     defaultExpire,
+		cfg:               cfg,
+		revalidate:        revalidate,
+	}
+}
+
+func (core *cacheCore) serve(c cacheContext) error {
+	cfg := core.cfg
+	cacheStrategy, shouldCache := c.strategy()
+	if !shouldCache {
+		return c.next()
+	}
+
+	cacheKey := cacheStrategy.CacheKey
+
+	if cfg.prefixKey != "" {
+		cacheKey = cfg.prefixKey + cacheKey
+	}
+
+	for _, k := range cfg.keyWithGinContext {
+		cacheKey = c.value(k) + cacheKey
+	}
+
+	// merge cfg
+	cacheStore := core.defaultCacheStore
+	storeName := cfg.statsStore
+	if cacheStrategy.CacheStore != nil {
+		cacheStore = cacheStrategy.CacheStore
+		storeName = cacheStrategy.CacheStoreName
+	}
+
+	cacheDuration := core.defaultExpire
+	if cacheStrategy.CacheDuration > 0 {
+		cacheDuration = cacheStrategy.CacheDuration
+	}
+
+	staleWhileRevalidate := cfg.staleWhileRevalidate
+	if cacheStrategy.StaleWhileRevalidate > 0 {
+		staleWhileRevalidate = cacheStrategy.StaleWhileRevalidate
+	}
+	if !core.revalidate && staleWhileRevalidate > 0 {
+		cfg.logger.Errorf("stale-while-revalidate is not supported by the middleware, cache key: %s", cacheKey)
+		staleWhileRevalidate = 0
+	}
+
+	staleIfError := cfg.staleIfError
+	if cacheStrategy.StaleIfError > 0 {
+		staleIfError = cacheStrategy.StaleIfError
+	}
+
+	// the response is kept in the store after expiration for the stale windows
+	staleWindow := staleWhileRevalidate
+	if staleIfError > staleWindow {
+		staleWindow = staleIfError
+	}
+
+	record := func(kind statsKind, delta int64) {}
+	if cfg.stats != nil {
+		route := cfg.statsRoute
+		if route == "" {
+			route = c.route()
+		}
+		record = func(kind statsKind, delta int64) {
+			cfg.stats.add(route, storeName, cacheStore, kind, delta)
+		}
+	}
+	event := func(kind statsKind) {
+		record(kind, 1)
+		c.callback(kind)
+	}
+
+	req := c.request()
+
+	reqControl := cacheControl{maxAge: -1, sMaxAge: -1}
+	if cfg.respectCacheControl {
+		reqControl = parseCacheControl(req.Header)
+		if reqControl.noStore {
+			return c.next()
+		}
+	}
+
+	// fetch calls the backend and caches the 2xx response
+	fetch := func(c cacheContext) (*ResponseCache, error) {
+		err := c.run()
+
+		status, header, body := c.response()
+		respCache := &ResponseCache{}
+		respCache.fill(status, header, body, cfg.withoutHeader, cfg.withoutHeaderIgnore)
+
+		// only cache 2xx response
+		if err != nil || c.aborted() || !isSuccess(respCache.Status) {
+			return respCache, err
+		}
+
+		duration := cacheDuration
+		key := cacheKey
+		if cfg.respectCacheControl {
+			duration = parseCacheControl(header).ttl(cacheDuration)
+			vary, star := parseVary(header)
+			if duration <= 0 || star {
+				return respCache, nil
+			}
+			if len(vary) > 0 {
+				key = getVaryKey(cacheKey, vary, req)
+				respCache.Vary = vary
+				respCache.VaryKey = key
+				marker := &ResponseCache{Vary: vary}
+				if err := setCache(cacheStore, cacheKey, marker, duration+staleWindow, cacheStrategy.Tags); err != nil {
+					cfg.logger.Errorf("set cache key error: %s, cache key: %s", err, cacheKey)
+					record(statsSetError, 1)
+				}
+			}
+		}
+		if staleWindow > 0 {
+			respCache.ExpireAt = respCache.Date.Add(duration)
+		}
+		// the client can make conditional requests from the first response
+		c.validate(respCache.ETag, respCache.LastModified)
+
+		if err := setCache(cacheStore, key, respCache, duration+staleWindow, cacheStrategy.Tags); err != nil {
+			cfg.logger.Errorf("set cache key error: %s, cache key: %s", err, key)
+			record(statsSetError, 1)
+		} else {
+			record(statsBytes, int64(len(respCache.Data)))
+		}
+		return respCache, nil
+	}
+
+	// read cache first
+	flightKey := cacheKey
+	var staleCache *ResponseCache
+	// the warmer refreshes the cache regardless of the cached response
+	if !c.warming() {
+		respCache, key, err := getCache(cacheStore, cacheKey, req)
+		flightKey = key
+		if err == nil {
+			now := time.Now()
+			// the client asks for revalidation
+			revalidate := reqControl.noCache || (reqControl.maxAge >= 0 && now.Sub(respCache.Date) > reqControl.maxAge)
+			if !revalidate && respCache.isFresh(now) {
+				c.reply(respCache)
+				event(statsHit)
+				return nil
+			}
+
+			if !revalidate && respCache.isStaleWithin(now, staleWhileRevalidate) {
+				c.reply(respCache)
+				event(statsStaleHit)
+				if _, loaded := core.refreshing.LoadOrStore(flightKey, struct{}{}); !loaded {
+					c.refresh(func(c cacheContext) {
+						fetch(c)
+					}, func() {
+						core.refreshing.Delete(flightKey)
+					})
+				}
+				return nil
+			}
+
+			if respCache.isStaleWithin(now, staleIfError) {
+				staleCache = respCache
+			}
+		} else if err != persist.ErrCacheMiss {
+			cfg.logger.Errorf("get cache error: %s, cache key: %s", err, key)
+		}
+		event(statsMiss)
+	}
+
+	// cache miss, then call the backend
+
+	// hold the response back, so the validators are set before it is sent,
+	// and the stale cache is replied instead if the backend fails
+	c.record()
+
+	inFlight := false
+	rawRespCache, err, _ := core.sfGroup.Do(flightKey, func() (interface{}, error) {
+		if cfg.singleFlightForgetTimeout > 0 {
+			forgetTimer := time.AfterFunc(cfg.singleFlightForgetTimeout, func() {
+				core.sfGroup.Forget(flightKey)
+			})
+			defer forgetTimer.Stop()
+		}
+
+		if cfg.locker != nil {
+			unlock, respCache := cfg.lockFlight(cacheStore, cacheKey, flightKey, req)
+			if respCache != nil {
+				// computed by another process
+				return respCache, nil
+			}
+			if unlock != nil {
+				defer unlock()
+			}
+		}
+
+		respCache, err := fetch(c)
+
+		inFlight = true
+		return respCache, err
+	})
+	respCache := rawRespCache.(*ResponseCache)
+
+	// the shared response varies on request headers, and does not match this request
+	if !inFlight && err == nil && respCache.VaryKey != "" && getVaryKey(cacheKey, respCache.Vary, req) != respCache.VaryKey {
+		respCache, err = fetch(c)
+		inFlight = true
+	}
+
+	if staleCache != nil && (err != nil || !isSuccess(respCache.Status) || (inFlight && c.aborted())) {
+		// drop the failed response, and reply with the stale cache
+		c.discard()
+		c.reply(staleCache)
+		event(statsStaleIfError)
+		return nil
+	}
+
+	if inFlight {
+		if err := c.flush(); err != nil {
+			cfg.logger.Errorf("write response error: %s", err)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	c.reply(respCache)
+	event(statsSharedFlight)
+	return nil
+}
+
+// getCache retrieves the response from store, the variant is retrieved if a vary marker is stored under the key.
+// The key of the response is returned.
+func getCache(cacheStore persist.CacheStore, cacheKey string, req *http.Request) (*ResponseCache, string, error) {
+	respCache := &ResponseCache{}
+	if err := cacheStore.Get(cacheKey, &respCache); err != nil {
+		return nil, cacheKey, err
+	}
+	if !respCache.isVaryMarker() {
+		return respCache, cacheKey, nil
+	}
+
+	varyKey := getVaryKey(cacheKey, respCache.Vary, req)
+	variant := &ResponseCache{}
+	if err := cacheStore.Get(varyKey, &variant); err != nil {
+		return nil, varyKey, err
+	}
+	return variant, varyKey, nil
+}
+
+func isSuccess(status int) bool {
+	return status < 300 && status >= 200
+}
+
+func setCache(cacheStore persist.CacheStore, key string, value interface{}, expire time.Duration, tags []string) error {
+	if store, ok := cacheStore.(persist.InvalidatableStore); ok && len(tags) > 0 {
+		return store.SetWithTags(key, value, expire, tags...)
+	}
+	return cacheStore.Set(key, value, expire)
+}
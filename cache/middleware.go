@@ -8,13 +8,18 @@ import (
 	"github.com/baetyl/baetyl-go/v2/cache/persist"
 )
 
-// MCache user must pass getCacheKey to describe the way to generate cache key
+// MCache user must pass getCacheKey to describe the way to generate cache key.
+// It panics if WithStaleWhileRevalidate is passed, because the middleware can not re-run the rest of the handler chain
+// in background, use the wrapper instead, e.g. WCache.
 func MCache(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, opts ...Option) gin.HandlerFunc {
 	cfg := newConfigByOpts(opts...)
 	return mCache(defaultCacheStore, defaultExpire, cfg)
 }
 
 func mCache(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, cfg *Config) gin.HandlerFunc {
+	if cfg.staleWhileRevalidate > 0 {
+		panic("stale-while-revalidate is not supported by the middleware, use the wrapper instead")
+	}
 	return _cache(defaultCacheStore, defaultExpire, cfg, true, nil)
 }
 
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
 )
 
 // Option represents the optional function.
@@ -68,6 +70,58 @@ func WithOnMissCache(cb OnMissCacheCallback) Option {
 	}
 }
 
+// OnHitStaleCacheCallback define the callback when use stale cache
+type OnHitStaleCacheCallback func(c *gin.Context)
+
+var defaultHitStaleCacheCallback = func(c *gin.Context) {}
+
+// WithOnHitStaleCache will be called when a stale cache is replied while it is revalidated in background.
+func WithOnHitStaleCache(cb OnHitStaleCacheCallback) Option {
+	return func(c *Config) {
+		if cb != nil {
+			c.hitStaleCacheCallback = cb
+		}
+	}
+}
+
+// OnStaleIfErrorCallback define the callback when use stale cache because of backend error
+type OnStaleIfErrorCallback func(c *gin.Context)
+
+var defaultStaleIfErrorCallback = func(c *gin.Context) {}
+
+// WithOnStaleIfError will be called when a stale cache is replied because the backend failed.
+func WithOnStaleIfError(cb OnStaleIfErrorCallback) Option {
+	return func(c *Config) {
+		if cb != nil {
+			c.staleIfErrorCallback = cb
+		}
+	}
+}
+
+// WithStaleWhileRevalidate set the default stale-while-revalidate window, the Strategy of request takes precedence.
+// Within the window after expiration, the stale cache is replied immediately and refreshed in background.
+// It is only supported by the wrapper, e.g. WCache, and the handlers of fasthttp-routing, because the gin middleware
+// can not re-run the rest of the handler chain in background. MCache panics if the option is passed.
+// The handlers of fasthttp-routing refresh by replaying the request to the router, which runs all the middlewares
+// of the route again, see IsReplayed.
+func WithStaleWhileRevalidate(window time.Duration) Option {
+	return func(c *Config) {
+		if window > 0 {
+			c.staleWhileRevalidate = window
+		}
+	}
+}
+
+// WithStaleIfError set the default stale-if-error window, the Strategy of request takes precedence.
+// Within the window after expiration, the stale cache is replied if the backend aborts or replies a non-2xx status.
+func WithStaleIfError(window time.Duration) Option {
+	return func(c *Config) {
+		if window > 0 {
+			c.staleIfError = window
+		}
+	}
+}
+
 type BeforeReplyWithCacheCallback func(c *gin.Context, cache *ResponseCache)
 
 var defaultBeforeReplyWithCacheCallback = func(c *gin.Context, cache *ResponseCache) {}
@@ -105,6 +159,32 @@ func WithSingleFlightForgetTimeout(forgetTimeout time.Duration) Option {
 	}
 }
 
+// WithDistributedSingleFlight makes the processes sharing the locker and the cache store compute a missing cache once.
+// The process holding the lease of a cache key calls the backend, the others wait for the cache to be set.
+// The lease is renewed while the backend is called, so it only expires if the holder crashes, then another process
+// takes it over. Like WithSingleFlightForgetTimeout, the others give up waiting and call the backend themselves
+// when forgetTimeout is reached.
+func WithDistributedSingleFlight(locker persist.Locker, lease time.Duration) Option {
+	return func(c *Config) {
+		if locker != nil && lease > 0 {
+			c.locker = locker
+			c.lockLease = lease
+		}
+	}
+}
+
+// WithStats collects the statistics to stats under the route label and the store label of the default cache store,
+// see Strategy.CacheStoreName for the store of a strategy. If route is empty, the gin route template is used,
+// and "other" is used for the requests without a template and for fasthttp-routing, which exposes no template.
+// The store is only counted if it is named, and the stores with the same name are counted together.
+func WithStats(stats *Stats, route, store string) Option {
+	return func(c *Config) {
+		c.stats = stats
+		c.statsRoute = route
+		c.statsStore = store
+	}
+}
+
 // IgnoreQueryOrder will ignore the queries order in url when generate cache key . This option only takes effect in CacheByRequestURI function
 func IgnoreQueryOrder() Option {
 	return func(c *Config) {
@@ -119,6 +199,15 @@ func WithPrefixKey(prefix string) Option {
 	}
 }
 
+// RespectCacheControl enables the RFC 7234 style caching. The request with Cache-Control no-store bypasses the cache,
+// no-cache or an exceeded max-age revalidates the cache. The response Cache-Control decides whether and how long it is cached,
+// and the request headers listed in the response Vary are folded into the cache key.
+func RespectCacheControl() Option {
+	return func(c *Config) {
+		c.respectCacheControl = true
+	}
+}
+
 func WithoutHeader() Option {
 	return func(c *Config) {
 		c.withoutHeader = true
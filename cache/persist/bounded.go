@@ -0,0 +1,252 @@
+package persist
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy decides which item is evicted first when the store is full
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used item first
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used item first, ties are broken by recency
+	EvictLFU
+)
+
+// BoundedMemoryStats the counters of BoundedMemoryStore
+type BoundedMemoryStats struct {
+	Items       int
+	Bytes       int64
+	MaxBytes    int64
+	Evictions   uint64
+	Expirations uint64
+}
+
+type boundedItem struct {
+	key      string
+	payload  []byte
+	expireAt time.Time
+	hits     uint64
+	access   uint64
+	index    int
+}
+
+// boundedQueue is a min heap, the item on top is evicted first
+type boundedQueue struct {
+	policy EvictionPolicy
+	items  []*boundedItem
+}
+
+func (q *boundedQueue) Len() int { return len(q.items) }
+
+func (q *boundedQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if q.policy == EvictLFU && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.access < b.access
+}
+
+func (q *boundedQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *boundedQueue) Push(x interface{}) {
+	item := x.(*boundedItem)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *boundedQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	item.index = -1
+	return item
+}
+
+// BoundedMemoryStore represents the cache with memory persistence, the total serialized size of values
+// is limited by maxBytes, items are evicted by the EvictionPolicy when the limit is exceeded
+type BoundedMemoryStore struct {
+	// Serializer if nil, values are serialized by gob
+	Serializer Serializer
+
+	defaultExpiration time.Duration
+	maxBytes          int64
+	bytes             int64
+	clock             uint64
+	items             map[string]*boundedItem
+	queue             *boundedQueue
+	index             *keyIndex
+	lock              sync.Mutex
+
+	evictions   uint64
+	expirations uint64
+}
+
+// NewBoundedMemoryStore returns a BoundedMemoryStore, it can be used in place of NewInMemoryStore
+func NewBoundedMemoryStore(defaultExpiration time.Duration, maxBytes int64, policy EvictionPolicy) *BoundedMemoryStore {
+	return &BoundedMemoryStore{
+		defaultExpiration: defaultExpiration,
+		maxBytes:          maxBytes,
+		items:             map[string]*boundedItem{},
+		queue:             &boundedQueue{policy: policy},
+		index:             newKeyIndex(),
+	}
+}
+
+// Get (see CacheStore interface)
+func (c *BoundedMemoryStore) Get(key string, value interface{}) error {
+	c.lock.Lock()
+	item, ok := c.items[key]
+	if !ok {
+		c.lock.Unlock()
+		return ErrCacheMiss
+	}
+	if !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+		c.remove(item)
+		c.lock.Unlock()
+		atomic.AddUint64(&c.expirations, 1)
+		return ErrCacheMiss
+	}
+	c.clock++
+	item.access = c.clock
+	item.hits++
+	heap.Fix(c.queue, item.index)
+	payload := item.payload
+	c.lock.Unlock()
+
+	return deserialize(c.Serializer, payload, value)
+}
+
+// Set (see CacheStore interface)
+// NOTE: like go-cache, expire 0 means the default expiration and a negative value means never expire
+func (c *BoundedMemoryStore) Set(key string, value interface{}, expire time.Duration) error {
+	return c.SetWithTags(key, value, expire)
+}
+
+// SetWithTags (see InvalidatableStore interface)
+func (c *BoundedMemoryStore) SetWithTags(key string, value interface{}, expire time.Duration, tags ...string) error {
+	payload, err := serialize(c.Serializer, value)
+	if err != nil {
+		return err
+	}
+	size := int64(len(payload))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return ErrNotStored
+	}
+	if expire == 0 {
+		expire = c.defaultExpiration
+	}
+	var expireAt time.Time
+	if expire > 0 {
+		expireAt = time.Now().Add(expire)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if old, ok := c.items[key]; ok {
+		c.remove(old)
+	}
+	c.clock++
+	item := &boundedItem{
+		key:      key,
+		payload:  payload,
+		expireAt: expireAt,
+		hits:     1,
+		access:   c.clock,
+	}
+	c.items[key] = item
+	heap.Push(c.queue, item)
+	c.bytes += size
+	c.index.add(key, time.Time{}, tags)
+
+	for c.maxBytes > 0 && c.bytes > c.maxBytes {
+		c.remove(c.victim(item))
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	return nil
+}
+
+// Delete (see CacheStore interface)
+func (c *BoundedMemoryStore) Delete(key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	item, ok := c.items[key]
+	if !ok {
+		return ErrCacheMiss
+	}
+	c.remove(item)
+	return nil
+}
+
+// DeleteByPrefix (see InvalidatableStore interface)
+func (c *BoundedMemoryStore) DeleteByPrefix(prefix string) error {
+	c.deleteKeys(c.index.prefixed(prefix))
+	return nil
+}
+
+// DeleteByTag (see InvalidatableStore interface)
+func (c *BoundedMemoryStore) DeleteByTag(tags ...string) error {
+	c.deleteKeys(c.index.tagged(tags...))
+	return nil
+}
+
+func (c *BoundedMemoryStore) deleteKeys(keys []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range keys {
+		if item, ok := c.items[key]; ok {
+			c.remove(item)
+		}
+	}
+}
+
+// Evictions returns the number of items evicted because of the size limit
+func (c *BoundedMemoryStore) Evictions() uint64 {
+	return atomic.LoadUint64(&c.evictions)
+}
+
+// Stats returns the counters of the store
+func (c *BoundedMemoryStore) Stats() BoundedMemoryStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return BoundedMemoryStats{
+		Items:       len(c.items),
+		Bytes:       c.bytes,
+		MaxBytes:    c.maxBytes,
+		Evictions:   atomic.LoadUint64(&c.evictions),
+		Expirations: atomic.LoadUint64(&c.expirations),
+	}
+}
+
+// victim returns the item to evict, the item just set is never chosen,
+// otherwise a new item could be evicted at once by EvictLFU
+func (c *BoundedMemoryStore) victim(current *boundedItem) *boundedItem {
+	top := c.queue.items[0]
+	if top != current {
+		return top
+	}
+	// the second one in a heap is one of the children of the top
+	victim := c.queue.items[1]
+	if len(c.queue.items) > 2 && c.queue.Less(2, 1) {
+		victim = c.queue.items[2]
+	}
+	return victim
+}
+
+func (c *BoundedMemoryStore) remove(item *boundedItem) {
+	heap.Remove(c.queue, item.index)
+	delete(c.items, item.key)
+	c.bytes -= int64(len(item.payload))
+	c.index.delete(item.key)
+}
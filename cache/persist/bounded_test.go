@@ -0,0 +1,71 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundedMemoryStore(t *testing.T) {
+	memoryStore := NewBoundedMemoryStore(1*time.Minute, 0, EvictLRU)
+
+	expectVal := "123"
+	require.Nil(t, memoryStore.Set("test", expectVal, 1*time.Second))
+
+	value := ""
+	assert.Nil(t, memoryStore.Get("test", &value))
+	assert.Equal(t, expectVal, value)
+
+	time.Sleep(1 * time.Second)
+	assert.Equal(t, ErrCacheMiss, memoryStore.Get("test", &value))
+	assert.Equal(t, uint64(1), memoryStore.Stats().Expirations)
+
+	require.Nil(t, memoryStore.Set("test", expectVal, 0))
+	assert.Nil(t, memoryStore.Delete("test"))
+	assert.Equal(t, ErrCacheMiss, memoryStore.Delete("test"))
+	assert.Equal(t, int64(0), memoryStore.Stats().Bytes)
+}
+
+func TestBoundedMemoryStoreEviction(t *testing.T) {
+	payload, err := Serialize("value")
+	require.Nil(t, err)
+	size := int64(len(payload))
+
+	// lru
+	lruStore := NewBoundedMemoryStore(time.Minute, size*2, EvictLRU)
+	require.Nil(t, lruStore.Set("k1", "value", 0))
+	require.Nil(t, lruStore.Set("k2", "value", 0))
+	value := ""
+	require.Nil(t, lruStore.Get("k1", &value))
+	require.Nil(t, lruStore.Set("k3", "value", 0))
+
+	assert.Nil(t, lruStore.Get("k1", &value))
+	assert.Equal(t, ErrCacheMiss, lruStore.Get("k2", &value))
+	assert.Nil(t, lruStore.Get("k3", &value))
+	assert.Equal(t, uint64(1), lruStore.Evictions())
+	stats := lruStore.Stats()
+	assert.Equal(t, 2, stats.Items)
+	assert.Equal(t, size*2, stats.Bytes)
+
+	// lfu
+	lfuStore := NewBoundedMemoryStore(time.Minute, size*2, EvictLFU)
+	require.Nil(t, lfuStore.Set("k1", "value", 0))
+	require.Nil(t, lfuStore.Set("k2", "value", 0))
+	require.Nil(t, lfuStore.Get("k1", &value))
+	require.Nil(t, lfuStore.Get("k1", &value))
+	require.Nil(t, lfuStore.Get("k2", &value))
+	require.Nil(t, lfuStore.Set("k3", "value", 0))
+
+	assert.Nil(t, lfuStore.Get("k1", &value))
+	assert.Equal(t, ErrCacheMiss, lfuStore.Get("k2", &value))
+	assert.Nil(t, lfuStore.Get("k3", &value))
+	assert.Equal(t, uint64(1), lfuStore.Evictions())
+
+	assert.Equal(t, ErrNotStored, lfuStore.Set("big", make([]byte, size*3), 0))
+}
+
+func TestBoundedMemoryStoreInvalidate(t *testing.T) {
+	testInvalidatableStore(t, NewBoundedMemoryStore(1*time.Minute, 0, EvictLRU))
+}
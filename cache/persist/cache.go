@@ -22,3 +22,17 @@ type CacheStore interface {
 	// Delete removes an item from the Cache. Does nothing if the key is not in the Cache.
 	Delete(key string) error
 }
+
+// InvalidatableStore is an optional interface of CacheStore to invalidate a group of items at once
+type InvalidatableStore interface {
+	CacheStore
+
+	// SetWithTags sets an item to the Cache like Set, and attaches the tags to it.
+	SetWithTags(key string, value interface{}, expire time.Duration, tags ...string) error
+
+	// DeleteByPrefix removes all items whose key starts with the prefix.
+	DeleteByPrefix(prefix string) error
+
+	// DeleteByTag removes all items attached with any of the tags.
+	DeleteByTag(tags ...string) error
+}
@@ -2,9 +2,23 @@ package persist
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/ugorji/go/codec"
 )
 
+// ErrUnsupportedValue the value cannot be handled by the codec
+var ErrUnsupportedValue = errors.New("cache: value is not supported by codec")
+
 // Serialize returns a []byte representing the passed value
 func Serialize(value interface{}) ([]byte, error) {
 	var b bytes.Buffer
@@ -15,7 +29,288 @@ func Serialize(value interface{}) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-// Deserialize will deserialize the passed []byte into the passed ptr interface{}
+// Deserialize will deserialize the passed []byte into the passed ptr interface{}.
+// The payload produced by any Serializer is accepted, the payload without header is decoded by gob.
 func Deserialize(payload []byte, ptr interface{}) (err error) {
+	if len(payload) > 0 && isHeader(payload[0]) {
+		return deserializeWithHeader(payload, ptr)
+	}
 	return gob.NewDecoder(bytes.NewBuffer(payload)).Decode(ptr)
 }
+
+// Serializer turns values into the payload in store and back
+type Serializer interface {
+	Serialize(value interface{}) ([]byte, error)
+	Deserialize(payload []byte, ptr interface{}) error
+}
+
+// Codec encodes values, the ID is recorded in the header byte of payload
+type Codec interface {
+	ID() uint8
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, ptr interface{}) error
+}
+
+// Compression the algorithm to compress payload, it is recorded in the header byte of payload
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+)
+
+// the ids of builtin codecs
+const (
+	CodecIDGob uint8 = iota + 1
+	CodecIDJSON
+	CodecIDMsgpack
+	CodecIDProtobuf
+)
+
+var (
+	GobCodec      Codec = gobCodec{}
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+var (
+	codecs    = map[uint8]Codec{}
+	codecLock sync.RWMutex
+)
+
+func init() {
+	for _, c := range []Codec{GobCodec, JSONCodec, MsgpackCodec, ProtobufCodec} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec registers a custom codec, so that the payload encoded by it can be decoded by Deserialize.
+// The id must be less than 8.
+func RegisterCodec(c Codec) {
+	if c.ID() >= 1<<3 {
+		panic(fmt.Sprintf("cache: codec id %d is out of range", c.ID()))
+	}
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[c.ID()] = c
+}
+
+func getCodec(id uint8) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	c, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("cache: codec %d is not registered", id)
+	}
+	return c, nil
+}
+
+// The header byte is 10cccmmm, c is the codec id and m is the compression.
+// A gob stream never starts with such a byte, its first byte is a length in [0x00, 0x7f] or [0xf8, 0xff].
+const (
+	headerMask byte = 0xc0
+	headerFlag byte = 0x80
+)
+
+func isHeader(b byte) bool {
+	return b&headerMask == headerFlag
+}
+
+func newHeader(codecID uint8, compression Compression) byte {
+	return headerFlag | codecID<<3 | byte(compression)
+}
+
+type serializer struct {
+	codec       Codec
+	compression Compression
+	threshold   int
+}
+
+// NewSerializer creates a Serializer which encodes values by codec, and compresses the payload
+// if it is larger than threshold bytes. The payload starts with a header byte of the codec and the compression,
+// so that any Serializer or Deserialize can decode it, and stores can be migrated between codecs.
+func NewSerializer(c Codec, compression Compression, threshold int) Serializer {
+	return &serializer{
+		codec:       c,
+		compression: compression,
+		threshold:   threshold,
+	}
+}
+
+func (s *serializer) Serialize(value interface{}) ([]byte, error) {
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := s.compression
+	if len(data) <= s.threshold {
+		compression = CompressionNone
+	}
+	var b bytes.Buffer
+	b.WriteByte(newHeader(s.codec.ID(), compression))
+	switch compression {
+	case CompressionNone:
+		b.Write(data)
+	case CompressionGzip:
+		w := gzip.NewWriter(&b)
+		if _, err = w.Write(data); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionSnappy:
+		b.Write(snappy.Encode(nil, data))
+	default:
+		return nil, fmt.Errorf("cache: unknown compression %d", compression)
+	}
+	return b.Bytes(), nil
+}
+
+func (s *serializer) Deserialize(payload []byte, ptr interface{}) error {
+	return Deserialize(payload, ptr)
+}
+
+func deserializeWithHeader(payload []byte, ptr interface{}) error {
+	header := payload[0]
+	c, err := getCodec(header >> 3 & 0x07)
+	if err != nil {
+		return err
+	}
+
+	data := payload[1:]
+	switch Compression(header & 0x07) {
+	case CompressionNone:
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	case CompressionSnappy:
+		if data, err = snappy.Decode(nil, data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cache: unknown compression %d", header&0x07)
+	}
+	return c.Unmarshal(data, ptr)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() uint8 { return CodecIDGob }
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	return Serialize(value)
+}
+
+func (gobCodec) Unmarshal(data []byte, ptr interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(ptr)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() uint8 { return CodecIDJSON }
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, ptr interface{}) error {
+	return json.Unmarshal(data, ptr)
+}
+
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() uint8 { return CodecIDMsgpack }
+
+func (msgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(value)
+	return data, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, ptr interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(ptr)
+}
+
+// protoMarshaler is implemented by the messages generated by gogo/protobuf and cache.ResponseCache,
+// whose protobuf encoding is hand-maintained
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type protoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// protobufCodec encodes the values implementing protoMarshaler or proto.Message
+type protobufCodec struct{}
+
+func (protobufCodec) ID() uint8 { return CodecIDProtobuf }
+
+func (protobufCodec) Marshal(value interface{}) ([]byte, error) {
+	switch m := value.(type) {
+	case protoMarshaler:
+		return m.Marshal()
+	case proto.Message:
+		return proto.Marshal(m)
+	default:
+		return nil, ErrUnsupportedValue
+	}
+}
+
+func (protobufCodec) Unmarshal(data []byte, ptr interface{}) error {
+	if ok, err := protoUnmarshal(data, ptr); ok {
+		return err
+	}
+	// pointer to a nil or non-nil pointer, such as the **ResponseCache used by cache middleware
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Ptr {
+		return ErrUnsupportedValue
+	}
+	elem := reflect.New(v.Elem().Type().Elem())
+	ok, err := protoUnmarshal(data, elem.Interface())
+	if !ok {
+		return ErrUnsupportedValue
+	}
+	if err != nil {
+		return err
+	}
+	v.Elem().Set(elem)
+	return nil
+}
+
+func protoUnmarshal(data []byte, ptr interface{}) (bool, error) {
+	switch m := ptr.(type) {
+	case protoUnmarshaler:
+		return true, m.Unmarshal(data)
+	case proto.Message:
+		return true, proto.Unmarshal(data, m)
+	default:
+		return false, nil
+	}
+}
+
+func serialize(s Serializer, value interface{}) ([]byte, error) {
+	if s == nil {
+		return Serialize(value)
+	}
+	return s.Serialize(value)
+}
+
+func deserialize(s Serializer, payload []byte, ptr interface{}) error {
+	if s == nil {
+		return Deserialize(payload, ptr)
+	}
+	return s.Deserialize(payload, ptr)
+}
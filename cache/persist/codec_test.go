@@ -1,6 +1,7 @@
 package persist
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -31,3 +32,43 @@ func TestCodec(t *testing.T) {
 	assert.Equal(t, src.B, dest.B)
 	assert.Equal(t, src.C, dest.C)
 }
+
+func TestSerializer(t *testing.T) {
+	src := &testStruct{
+		A: 1,
+		B: strings.Repeat("2", 100),
+	}
+	legacy, err := Serialize(src)
+	require.Nil(t, err)
+
+	for _, c := range []Codec{GobCodec, JSONCodec, MsgpackCodec} {
+		for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy} {
+			s := NewSerializer(c, compression, 10)
+			payload, err := s.Serialize(src)
+			require.Nil(t, err)
+			assert.Equal(t, newHeader(c.ID(), compression), payload[0])
+
+			var dest testStruct
+			require.Nil(t, s.Deserialize(payload, &dest))
+			assert.Equal(t, *src, dest)
+
+			// decoded by any serializer
+			dest = testStruct{}
+			require.Nil(t, Deserialize(payload, &dest))
+			assert.Equal(t, *src, dest)
+
+			// the payload of old versions
+			dest = testStruct{}
+			require.Nil(t, s.Deserialize(legacy, &dest))
+			assert.Equal(t, *src, dest)
+		}
+	}
+
+	// small payload is not compressed
+	payload, err := NewSerializer(JSONCodec, CompressionGzip, 1024).Serialize(src)
+	require.Nil(t, err)
+	assert.Equal(t, newHeader(CodecIDJSON, CompressionNone), payload[0])
+
+	_, err = NewSerializer(ProtobufCodec, CompressionNone, 0).Serialize(src)
+	assert.Equal(t, ErrUnsupportedValue, err)
+}
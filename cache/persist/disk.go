@@ -0,0 +1,319 @@
+package persist
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/context"
+)
+
+const (
+	diskStoreDirName = "cache"
+	diskFileSuffix   = ".cache"
+)
+
+// diskRecord the content of a cache file on disk
+type diskRecord struct {
+	Key      string
+	Tags     []string
+	ExpireAt time.Time
+	Payload  []byte
+}
+
+// diskEntry the in memory index of a cache file
+type diskEntry struct {
+	key      string
+	file     string
+	size     int64
+	expireAt time.Time
+}
+
+func (e *diskEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && now.After(e.expireAt)
+}
+
+// DiskStore store http response in local files, the total size on disk is limited by maxSize
+// and the least recently used entries are evicted first
+type DiskStore struct {
+	// Serializer if nil, values are serialized by gob
+	Serializer Serializer
+
+	dir     string
+	maxSize int64
+	size    int64
+	lru     *list.List
+	items   map[string]*list.Element
+	index   *keyIndex
+	lock    sync.Mutex
+
+	evictions uint64
+}
+
+// DefaultDiskStoreDir returns the default directory of DiskStore under the baetyl host path
+func DefaultDiskStoreDir() (string, error) {
+	hostPath, err := context.HostPathLib()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hostPath, diskStoreDirName), nil
+}
+
+// NewDiskStore create a disk store in dir, maxSize limits the total bytes on disk, no limit if maxSize <= 0.
+// Entries persisted by a previous store in the same dir are loaded, expired ones are removed.
+func NewDiskStore(dir string, maxSize int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	store := &DiskStore{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   map[string]*list.Element{},
+		index:   newKeyIndex(),
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Get (see CacheStore interface)
+func (store *DiskStore) Get(key string, value interface{}) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	elem, ok := store.items[key]
+	if !ok {
+		return ErrCacheMiss
+	}
+	entry := elem.Value.(*diskEntry)
+	if entry.expired(time.Now()) {
+		store.removeElement(elem)
+		return ErrCacheMiss
+	}
+
+	record, err := readDiskRecord(entry.file)
+	if err != nil {
+		store.removeElement(elem)
+		return ErrCacheMiss
+	}
+	store.lru.MoveToFront(elem)
+	now := time.Now()
+	// the modification time keeps the lru order across restarts
+	os.Chtimes(entry.file, now, now)
+	return deserialize(store.Serializer, record.Payload, value)
+}
+
+// Set (see CacheStore interface), the item never expires if expire <= 0
+func (store *DiskStore) Set(key string, value interface{}, expire time.Duration) error {
+	return store.SetWithTags(key, value, expire)
+}
+
+// SetWithTags (see InvalidatableStore interface)
+func (store *DiskStore) SetWithTags(key string, value interface{}, expire time.Duration, tags ...string) error {
+	payload, err := serialize(store.Serializer, value)
+	if err != nil {
+		return err
+	}
+	record := &diskRecord{
+		Key:     key,
+		Tags:    tags,
+		Payload: payload,
+	}
+	if expire > 0 {
+		record.ExpireAt = time.Now().Add(expire)
+	}
+	data, err := Serialize(record)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if store.maxSize > 0 && size > store.maxSize {
+		return ErrNotStored
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	file := store.filename(key)
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if elem, ok := store.items[key]; ok {
+		store.size -= elem.Value.(*diskEntry).size
+		store.lru.Remove(elem)
+	}
+	store.items[key] = store.lru.PushFront(&diskEntry{
+		key:      key,
+		file:     file,
+		size:     size,
+		expireAt: record.ExpireAt,
+	})
+	store.size += size
+	store.index.add(key, time.Time{}, tags)
+	store.evict()
+	return nil
+}
+
+// Delete (see CacheStore interface), does nothing if the key is not in the store
+func (store *DiskStore) Delete(key string) error {
+	store.deleteKeys([]string{key})
+	return nil
+}
+
+// DeleteByPrefix (see InvalidatableStore interface)
+func (store *DiskStore) DeleteByPrefix(prefix string) error {
+	store.deleteKeys(store.index.prefixed(prefix))
+	return nil
+}
+
+// DeleteByTag (see InvalidatableStore interface)
+func (store *DiskStore) DeleteByTag(tags ...string) error {
+	store.deleteKeys(store.index.tagged(tags...))
+	return nil
+}
+
+func (store *DiskStore) deleteKeys(keys []string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, key := range keys {
+		if elem, ok := store.items[key]; ok {
+			store.removeElement(elem)
+		}
+	}
+}
+
+// Size returns the total bytes of cache files on disk
+func (store *DiskStore) Size() int64 {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.size
+}
+
+// Evictions returns the number of entries evicted because of the size limit
+func (store *DiskStore) Evictions() uint64 {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.evictions
+}
+
+func (store *DiskStore) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(store.dir, hex.EncodeToString(sum[:])+diskFileSuffix)
+}
+
+// evict removes expired entries first, then the least recently used ones until size fits maxSize
+func (store *DiskStore) evict() {
+	if store.maxSize <= 0 || store.size <= store.maxSize {
+		return
+	}
+	now := time.Now()
+	for elem := store.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if elem.Value.(*diskEntry).expired(now) {
+			store.removeElement(elem)
+		}
+		elem = prev
+	}
+	for store.size > store.maxSize {
+		elem := store.lru.Back()
+		if elem == nil {
+			return
+		}
+		store.removeElement(elem)
+		store.evictions++
+	}
+}
+
+func (store *DiskStore) removeElement(elem *list.Element) {
+	entry := store.lru.Remove(elem).(*diskEntry)
+	delete(store.items, entry.key)
+	store.index.delete(entry.key)
+	store.size -= entry.size
+	os.Remove(entry.file)
+}
+
+func (store *DiskStore) load() error {
+	files, err := os.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		entry   *diskEntry
+		tags    []string
+		modTime time.Time
+	}
+	var entries []loaded
+	now := time.Now()
+	for _, f := range files {
+		file := filepath.Join(store.dir, f.Name())
+		if f.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(f.Name(), diskFileSuffix) {
+			// leftover of an interrupted write
+			if strings.HasSuffix(f.Name(), diskFileSuffix+".tmp") {
+				os.Remove(file)
+			}
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		record, err := readDiskRecord(file)
+		if err != nil {
+			os.Remove(file)
+			continue
+		}
+		entry := &diskEntry{
+			key:      record.Key,
+			file:     file,
+			size:     info.Size(),
+			expireAt: record.ExpireAt,
+		}
+		if entry.expired(now) {
+			os.Remove(file)
+			continue
+		}
+		entries = append(entries, loaded{entry: entry, tags: record.Tags, modTime: info.ModTime()})
+	}
+
+	// the least recently used entry is at the back
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, l := range entries {
+		store.items[l.entry.key] = store.lru.PushFront(l.entry)
+		store.size += l.entry.size
+		store.index.add(l.entry.key, time.Time{}, l.tags)
+	}
+	store.evict()
+	return nil
+}
+
+func readDiskRecord(file string) (*diskRecord, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	record := &diskRecord{}
+	if err = Deserialize(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,86 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	diskStore, err := NewDiskStore(dir, 0)
+	require.Nil(t, err)
+
+	expectVal := "123"
+	require.Nil(t, diskStore.Set("test", expectVal, 1*time.Second))
+	require.Nil(t, diskStore.Set("forever", expectVal, 0))
+
+	value := ""
+	assert.Nil(t, diskStore.Get("test", &value))
+	assert.Equal(t, expectVal, value)
+
+	// reopen the store, entries and ttl survive
+	diskStore, err = NewDiskStore(dir, 0)
+	require.Nil(t, err)
+	value = ""
+	assert.Nil(t, diskStore.Get("test", &value))
+	assert.Equal(t, expectVal, value)
+
+	time.Sleep(1 * time.Second)
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("test", &value))
+	assert.Nil(t, diskStore.Get("forever", &value))
+
+	assert.Nil(t, diskStore.Delete("forever"))
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("forever", &value))
+	// deleting a missing key does nothing
+	assert.Nil(t, diskStore.Delete("forever"))
+	assert.Equal(t, int64(0), diskStore.Size())
+}
+
+func TestDiskStoreEviction(t *testing.T) {
+	dir := t.TempDir()
+	probe, err := NewDiskStore(t.TempDir(), 0)
+	require.Nil(t, err)
+	require.Nil(t, probe.Set("k1", "value", time.Minute))
+	entrySize := probe.Size()
+
+	diskStore, err := NewDiskStore(dir, entrySize*2)
+	require.Nil(t, err)
+	require.Nil(t, diskStore.Set("k1", "value", time.Minute))
+	require.Nil(t, diskStore.Set("k2", "value", time.Minute))
+
+	// touch k1, then k2 is the least recently used
+	value := ""
+	require.Nil(t, diskStore.Get("k1", &value))
+	require.Nil(t, diskStore.Set("k3", "value", time.Minute))
+
+	assert.Nil(t, diskStore.Get("k1", &value))
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("k2", &value))
+	assert.Nil(t, diskStore.Get("k3", &value))
+	assert.True(t, diskStore.Size() <= entrySize*2)
+	assert.Equal(t, uint64(1), diskStore.Evictions())
+
+	// the limit applies to the entries loaded from disk as well
+	diskStore, err = NewDiskStore(dir, entrySize)
+	require.Nil(t, err)
+	assert.Equal(t, entrySize, diskStore.Size())
+
+	assert.Equal(t, ErrNotStored, diskStore.Set("big", make([]byte, entrySize*2), time.Minute))
+}
+
+func TestDiskStoreInvalidate(t *testing.T) {
+	dir := t.TempDir()
+	diskStore, err := NewDiskStore(dir, 0)
+	require.Nil(t, err)
+	testInvalidatableStore(t, diskStore)
+
+	// tags are loaded from disk
+	require.Nil(t, diskStore.SetWithTags("k1", "v", time.Minute, "t1"))
+	diskStore, err = NewDiskStore(dir, 0)
+	require.Nil(t, err)
+	require.Nil(t, diskStore.DeleteByTag("t1"))
+	value := ""
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("k1", &value))
+}
@@ -0,0 +1,114 @@
+package persist
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyIndex records the keys and tags of the stores which cannot scan or group their items
+type keyIndex struct {
+	keys map[string]*indexEntry
+	tags map[string]map[string]struct{}
+	// the number of keys after last sweep, expired keys are swept when the index doubles
+	swept int
+	lock  sync.Mutex
+}
+
+type indexEntry struct {
+	tags     []string
+	expireAt time.Time
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		keys: map[string]*indexEntry{},
+		tags: map[string]map[string]struct{}{},
+	}
+}
+
+// add records the key with its tags, the previous tags of the key are replaced
+func (i *keyIndex) add(key string, expireAt time.Time, tags []string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.remove(key)
+	i.keys[key] = &indexEntry{tags: tags, expireAt: expireAt}
+	for _, tag := range tags {
+		keys, ok := i.tags[tag]
+		if !ok {
+			keys = map[string]struct{}{}
+			i.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	if len(i.keys) > 2*i.swept {
+		i.sweep(time.Now())
+	}
+}
+
+// delete removes the key and its tags from the index
+func (i *keyIndex) delete(key string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.remove(key)
+}
+
+// prefixed returns the keys with the prefix
+func (i *keyIndex) prefixed(prefix string) []string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var res []string
+	for key := range i.keys {
+		if strings.HasPrefix(key, prefix) {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// tagged returns the keys with any of the tags
+func (i *keyIndex) tagged(tags ...string) []string {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var res []string
+	seen := map[string]struct{}{}
+	for _, tag := range tags {
+		for key := range i.tags[tag] {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+func (i *keyIndex) remove(key string) {
+	entry, ok := i.keys[key]
+	if !ok {
+		return
+	}
+	delete(i.keys, key)
+	for _, tag := range entry.tags {
+		if keys, ok := i.tags[tag]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(i.tags, tag)
+			}
+		}
+	}
+}
+
+func (i *keyIndex) sweep(now time.Time) {
+	for key, entry := range i.keys {
+		if !entry.expireAt.IsZero() && now.After(entry.expireAt) {
+			i.remove(key)
+		}
+	}
+	i.swept = len(i.keys)
+}
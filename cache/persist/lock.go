@@ -0,0 +1,76 @@
+package persist
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const redisLockKeyPrefix = "cache:lock:"
+
+// Locker is a lease based distributed lock, the lease expires by itself if the holder crashes
+type Locker interface {
+	// Lock acquires the lock of key for the lease, returns false if the lock is held by others.
+	// The token is used to release the lock.
+	Lock(key string, lease time.Duration) (token string, ok bool, err error)
+
+	// Extend renews the lease of the lock of key if it is still held by the token, returns false if it is not
+	Extend(key, token string, lease time.Duration) (bool, error)
+
+	// Unlock releases the lock of key if it is still held by the token
+	Unlock(key, token string) error
+}
+
+// redisUnlockScript deletes KEYS[1] only if its value is ARGV[1], so an expired lease never releases the lock of others
+var redisUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// redisExtendScript renews the lease of KEYS[1] to ARGV[2] milliseconds only if its value is ARGV[1]
+var redisExtendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RedisLocker implements Locker by redis SET NX with ttl
+type RedisLocker struct {
+	RedisClient *redis.Client
+}
+
+// NewRedisLocker create a redis locker with redis client
+func NewRedisLocker(redisClient *redis.Client) *RedisLocker {
+	return &RedisLocker{
+		RedisClient: redisClient,
+	}
+}
+
+// Lock (see Locker interface)
+func (l *RedisLocker) Lock(key string, lease time.Duration) (string, bool, error) {
+	token := uuid.New().String()
+	ok, err := l.RedisClient.SetNX(context.TODO(), redisLockKeyPrefix+key, token, lease).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Extend (see Locker interface)
+func (l *RedisLocker) Extend(key, token string, lease time.Duration) (bool, error) {
+	n, err := redisExtendScript.Run(context.TODO(), l.RedisClient, []string{redisLockKeyPrefix + key}, token, lease.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Unlock (see Locker interface)
+func (l *RedisLocker) Unlock(key, token string) error {
+	return redisUnlockScript.Run(context.TODO(), l.RedisClient, []string{redisLockKeyPrefix + key}, token).Err()
+}
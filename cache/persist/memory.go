@@ -10,11 +10,18 @@ import (
 // InMemoryStore represents the cache with memory persistence
 type InMemoryStore struct {
 	cache.Cache
+
+	defaultExpiration time.Duration
+	index             *keyIndex
 }
 
 // NewInMemoryStore returns a InMemoryStore
 func NewInMemoryStore(defaultExpiration time.Duration) *InMemoryStore {
-	return &InMemoryStore{*cache.New(defaultExpiration, time.Minute)}
+	return &InMemoryStore{
+		Cache:             *cache.New(defaultExpiration, time.Minute),
+		defaultExpiration: defaultExpiration,
+		index:             newKeyIndex(),
+	}
 }
 
 // Get (see CacheStore interface)
@@ -34,15 +41,46 @@ func (c *InMemoryStore) Get(key string, value interface{}) error {
 
 // Set (see CacheStore interface)
 func (c *InMemoryStore) Set(key string, value interface{}, expires time.Duration) error {
-	// NOTE: go-cache understands the values of DEFAULT and FOREVER
-	c.Cache.Set(key, value, expires)
-	return nil
+	return c.SetWithTags(key, value, expires)
 }
 
 // Delete (see CacheStore interface)
 func (c *InMemoryStore) Delete(key string) error {
+	c.index.delete(key)
 	if found := c.Cache.Delete(key); !found {
 		return ErrCacheMiss
 	}
 	return nil
 }
+
+// SetWithTags (see InvalidatableStore interface)
+func (c *InMemoryStore) SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error {
+	// NOTE: go-cache understands the values of DEFAULT and FOREVER
+	c.Cache.Set(key, value, expires)
+
+	if expires == 0 {
+		expires = c.defaultExpiration
+	}
+	var expireAt time.Time
+	if expires > 0 {
+		expireAt = time.Now().Add(expires)
+	}
+	c.index.add(key, expireAt, tags)
+	return nil
+}
+
+// DeleteByPrefix (see InvalidatableStore interface)
+func (c *InMemoryStore) DeleteByPrefix(prefix string) error {
+	for _, key := range c.index.prefixed(prefix) {
+		c.Delete(key)
+	}
+	return nil
+}
+
+// DeleteByTag (see InvalidatableStore interface)
+func (c *InMemoryStore) DeleteByTag(tags ...string) error {
+	for _, key := range c.index.tagged(tags...) {
+		c.Delete(key)
+	}
+	return nil
+}
@@ -21,3 +21,38 @@ func TestMemoryStore(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	assert.Equal(t, ErrCacheMiss, memoryStore.Get("test", &value))
 }
+
+func TestMemoryStoreInvalidate(t *testing.T) {
+	testInvalidatableStore(t, NewInMemoryStore(1*time.Minute))
+}
+
+func testInvalidatableStore(t *testing.T, store InvalidatableStore) {
+	require.Nil(t, store.Set("/nodes/n1?a=1&b=2", "v", time.Minute))
+	require.Nil(t, store.Set("/nodes/n1?b=2&a=1", "v", time.Minute))
+	require.Nil(t, store.Set("/nodes/n2", "v", time.Minute))
+	require.Nil(t, store.SetWithTags("u1/nodes/n1", "v", time.Minute, "n1"))
+	require.Nil(t, store.SetWithTags("u2/nodes/n1", "v", time.Minute, "n1", "u2"))
+	require.Nil(t, store.SetWithTags("u2/nodes/n2", "v", time.Minute, "u2"))
+
+	value := ""
+	require.Nil(t, store.DeleteByPrefix("/nodes/n1"))
+	assert.Equal(t, ErrCacheMiss, store.Get("/nodes/n1?a=1&b=2", &value))
+	assert.Equal(t, ErrCacheMiss, store.Get("/nodes/n1?b=2&a=1", &value))
+	assert.Nil(t, store.Get("/nodes/n2", &value))
+
+	require.Nil(t, store.DeleteByTag("n1"))
+	assert.Equal(t, ErrCacheMiss, store.Get("u1/nodes/n1", &value))
+	assert.Equal(t, ErrCacheMiss, store.Get("u2/nodes/n1", &value))
+	assert.Nil(t, store.Get("u2/nodes/n2", &value))
+
+	require.Nil(t, store.DeleteByTag("u2", "unknown"))
+	assert.Equal(t, ErrCacheMiss, store.Get("u2/nodes/n2", &value))
+	assert.Nil(t, store.Get("/nodes/n2", &value))
+
+	// prefix with glob characters
+	require.Nil(t, store.Set("/a*b", "v", time.Minute))
+	require.Nil(t, store.Set("/ab", "v", time.Minute))
+	require.Nil(t, store.DeleteByPrefix("/a*"))
+	assert.Equal(t, ErrCacheMiss, store.Get("/a*b", &value))
+	assert.Nil(t, store.Get("/ab", &value))
+}
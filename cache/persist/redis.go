@@ -3,14 +3,40 @@ package persist
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	redisTagKeyPrefix = "cache:tag:"
+	redisScanCount    = 100
+)
+
+// redisTagScript adds ARGV[1] to the tag sets in KEYS, and extends the expiration of the sets to ARGV[2] milliseconds
+var redisTagScript = redis.NewScript(`
+local expire = tonumber(ARGV[2])
+for _, tag in ipairs(KEYS) do
+	local added = redis.call('SADD', tag, ARGV[1])
+	local created = added == 1 and redis.call('SCARD', tag) == 1
+	local ttl = redis.call('PTTL', tag)
+	if expire <= 0 then
+		redis.call('PERSIST', tag)
+	elseif created or (ttl >= 0 and ttl < expire) then
+		redis.call('PEXPIRE', tag, expire)
+	end
+end
+return 0
+`)
+
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
 // RedisStore store http response in redis
 type RedisStore struct {
 	RedisClient *redis.Client
+	// Serializer if nil, values are serialized by gob
+	Serializer Serializer
 }
 
 // NewRedisStore create a redis memory store with redis client
@@ -22,7 +48,7 @@ func NewRedisStore(redisClient *redis.Client) *RedisStore {
 
 // Set put key value pair to redis, and expire after expireDuration
 func (store *RedisStore) Set(key string, value interface{}, expire time.Duration) error {
-	payload, err := Serialize(value)
+	payload, err := serialize(store.Serializer, value)
 	if err != nil {
 		return err
 	}
@@ -49,5 +75,108 @@ func (store *RedisStore) Get(key string, value interface{}) error {
 	if err != nil {
 		return err
 	}
-	return Deserialize(payload, value)
+	return deserialize(store.Serializer, payload, value)
+}
+
+// getWithTTL retrieves an item and its remaining ttl in one round trip, 0 means no expiration
+func (store *RedisStore) getWithTTL(key string, value interface{}) (time.Duration, error) {
+	ctx := context.TODO()
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := store.RedisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrCacheMiss
+	}
+	if err != nil {
+		return 0, err
+	}
+	payload, err := get.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	if err = deserialize(store.Serializer, payload, value); err != nil {
+		return 0, err
+	}
+	// -1 means no expiration, -2 means the key has just expired
+	ttl := pttl.Val()
+	if ttl == -2 {
+		return 0, ErrCacheMiss
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// SetWithTags put key value pair to redis like Set, the key is added to a redis set of each tag.
+// The set of a tag expires with the longest-lived key in it.
+func (store *RedisStore) SetWithTags(key string, value interface{}, expire time.Duration, tags ...string) error {
+	if err := store.Set(key, value, expire); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	tagKeys := make([]string, len(tags))
+	for i, tag := range tags {
+		tagKeys[i] = redisTagKeyPrefix + tag
+	}
+	ctx := context.TODO()
+	return redisTagScript.Run(ctx, store.RedisClient, tagKeys, key, expire.Milliseconds()).Err()
+}
+
+// DeleteByPrefix remove all keys with the prefix in redis by SCAN
+func (store *RedisStore) DeleteByPrefix(prefix string) error {
+	ctx := context.TODO()
+	iter := store.RedisClient.Scan(ctx, 0, redisGlobEscaper.Replace(prefix)+"*", redisScanCount).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= redisScanCount {
+			if err := store.RedisClient.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return store.RedisClient.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+// DeleteByTag remove all keys in the redis sets of the tags, and the sets themselves
+func (store *RedisStore) DeleteByTag(tags ...string) error {
+	ctx := context.TODO()
+	for _, tag := range tags {
+		keys, err := store.RedisClient.SMembers(ctx, redisTagKeyPrefix+tag).Result()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, redisTagKeyPrefix+tag)
+		if err = store.RedisClient.Del(ctx, keys...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taggedKeys returns the keys in the redis sets of the tags
+func (store *RedisStore) taggedKeys(tags ...string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	tagKeys := make([]string, len(tags))
+	for i, tag := range tags {
+		tagKeys[i] = redisTagKeyPrefix + tag
+	}
+	return store.RedisClient.SUnion(context.TODO(), tagKeys...).Result()
 }
@@ -0,0 +1,94 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client), mr
+}
+
+func TestRedisStore(t *testing.T) {
+	redisStore, mr := newTestRedisStore(t)
+
+	expectVal := "123"
+	require.Nil(t, redisStore.Set("test", expectVal, 1*time.Second))
+
+	value := ""
+	assert.Nil(t, redisStore.Get("test", &value))
+	assert.Equal(t, expectVal, value)
+
+	mr.FastForward(1 * time.Second)
+	assert.Equal(t, ErrCacheMiss, redisStore.Get("test", &value))
+}
+
+func TestRedisStoreInvalidate(t *testing.T) {
+	redisStore, mr := newTestRedisStore(t)
+	testInvalidatableStore(t, redisStore)
+
+	// the tag set lives as long as its longest-lived key
+	require.Nil(t, redisStore.SetWithTags("k1", "v", time.Minute, "t1"))
+	require.Nil(t, redisStore.SetWithTags("k2", "v", time.Hour, "t1"))
+	require.Nil(t, redisStore.SetWithTags("k3", "v", time.Second, "t1"))
+	assert.Equal(t, time.Hour, mr.TTL(redisTagKeyPrefix+"t1"))
+	require.Nil(t, redisStore.SetWithTags("k4", "v", 0, "t1"))
+	assert.Equal(t, time.Duration(0), mr.TTL(redisTagKeyPrefix+"t1"))
+
+	require.Nil(t, redisStore.DeleteByTag("t1"))
+	assert.False(t, mr.Exists(redisTagKeyPrefix+"t1"))
+	assert.False(t, mr.Exists("k2"))
+}
+
+func TestRedisLocker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	locker := NewRedisLocker(client)
+
+	token, ok, err := locker.Lock("key", time.Second)
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	_, ok, err = locker.Lock("key", time.Second)
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	// the wrong token does not release the lock
+	require.Nil(t, locker.Unlock("key", "other"))
+	_, ok, _ = locker.Lock("key", time.Second)
+	assert.False(t, ok)
+
+	require.Nil(t, locker.Unlock("key", token))
+	token, ok, _ = locker.Lock("key", time.Second)
+	assert.True(t, ok)
+
+	// the lease is renewed by the holder only
+	ok, err = locker.Extend("key", token, 2*time.Second)
+	require.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = locker.Extend("key", "other", 2*time.Second)
+	require.Nil(t, err)
+	assert.False(t, ok)
+	mr.FastForward(time.Second)
+	_, ok, _ = locker.Lock("key", time.Second)
+	assert.False(t, ok)
+
+	// the lease expires
+	mr.FastForward(time.Second)
+	_, ok, _ = locker.Lock("key", time.Second)
+	assert.True(t, ok)
+	require.Nil(t, locker.Unlock("key", token))
+	assert.True(t, mr.Exists(redisLockKeyPrefix+"key"))
+	ok, err = locker.Extend("key", token, time.Second)
+	require.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,272 @@
+package persist
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// DefaultInvalidationChannel the default redis pub/sub channel of TieredStore invalidation
+const DefaultInvalidationChannel = "cache:invalidation"
+
+const (
+	invalidateKey    = "key"
+	invalidatePrefix = "prefix"
+
+	// l1NoExpiration the expiration of the items never expiring in L1, the local stores take a negative one as no expiration
+	l1NoExpiration time.Duration = -1
+)
+
+// invalidation the message published to the other replicas
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+}
+
+// TieredStore is a two-tier cache store, a local L1 store is in front of the shared L2 redis store.
+// The writes on any replica are published over a redis pub/sub channel, and evict the L1 items of all other replicas.
+type TieredStore struct {
+	L1 CacheStore
+	L2 *RedisStore
+
+	// l1Expire limits how long an item stays in L1, since L1 does not know the remaining ttl in L2
+	l1Expire time.Duration
+	channel  string
+	id       string
+	pubsub   *redis.PubSub
+	done     chan struct{}
+
+	// fetches the keys being read from L2, whose version is bumped by the writes and invalidations,
+	// so that a value read before an invalidation is not cached in L1 after it
+	fetches map[string]*tieredFetch
+	lock    sync.Mutex
+}
+
+type tieredFetch struct {
+	version uint64
+	refs    int
+}
+
+// NewTieredStore creates a TieredStore and subscribes the invalidation channel, Close must be called to unsubscribe.
+// Items are kept in l1 for at most l1Expire, an item read from l2 is cached in l1 for l1Expire.
+// If l1Expire is 0, items are kept in l1 until they expire in l2, and the items without expiration in l2 are set
+// to l1 with a negative expiration, which means no expiration for the stores of this package.
+func NewTieredStore(l1 CacheStore, l2 *RedisStore, channel string, l1Expire time.Duration) (*TieredStore, error) {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	ctx := context.TODO()
+	ps := l2.RedisClient.Subscribe(ctx, channel)
+	// wait for the confirmation of subscription
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	store := &TieredStore{
+		L1:       l1,
+		L2:       l2,
+		l1Expire: l1Expire,
+		channel:  channel,
+		id:       uuid.New().String(),
+		pubsub:   ps,
+		done:     make(chan struct{}),
+		fetches:  map[string]*tieredFetch{},
+	}
+	go store.receiving()
+	return store, nil
+}
+
+// Get retrieves an item from L1 first, then from L2 and caches it in L1
+func (store *TieredStore) Get(key string, value interface{}) error {
+	if err := store.L1.Get(key, value); err == nil {
+		return nil
+	}
+
+	store.lock.Lock()
+	f, ok := store.fetches[key]
+	if !ok {
+		f = &tieredFetch{}
+		store.fetches[key] = f
+	}
+	f.refs++
+	version := f.version
+	store.lock.Unlock()
+
+	var expire time.Duration
+	var err error
+	if store.l1Expire > 0 {
+		expire, err = store.l1Expire, store.L2.Get(key, value)
+	} else {
+		expire, err = store.L2.getWithTTL(key, value)
+		expire = store.expireOfL1(expire)
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if f.refs--; f.refs == 0 {
+		delete(store.fetches, key)
+	}
+	if err != nil {
+		return err
+	}
+	// the key is written or invalidated while reading, the value may be stale
+	if f.version == version {
+		store.L1.Set(key, reflect.ValueOf(value).Elem().Interface(), expire)
+	}
+	return nil
+}
+
+// Set sets an item to both tiers, and evicts it from L1 of other replicas
+func (store *TieredStore) Set(key string, value interface{}, expire time.Duration) error {
+	if err := store.L2.Set(key, value, expire); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	store.bump(invalidateKey, key)
+	store.L1.Set(key, value, store.expireOfL1(expire))
+	store.lock.Unlock()
+	return store.publish(invalidateKey, key)
+}
+
+// Delete removes an item from both tiers, and evicts it from L1 of other replicas
+func (store *TieredStore) Delete(key string) error {
+	if err := store.L2.Delete(key); err != nil {
+		return err
+	}
+	store.invalidateL1(invalidateKey, key)
+	return store.publish(invalidateKey, key)
+}
+
+// SetWithTags (see InvalidatableStore interface)
+func (store *TieredStore) SetWithTags(key string, value interface{}, expire time.Duration, tags ...string) error {
+	if err := store.L2.SetWithTags(key, value, expire, tags...); err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.bump(invalidateKey, key)
+	if l1, ok := store.L1.(InvalidatableStore); ok {
+		l1.SetWithTags(key, value, store.expireOfL1(expire), tags...)
+	} else {
+		store.L1.Set(key, value, store.expireOfL1(expire))
+	}
+	return store.publish(invalidateKey, key)
+}
+
+// DeleteByPrefix (see InvalidatableStore interface)
+func (store *TieredStore) DeleteByPrefix(prefix string) error {
+	if err := store.L2.DeleteByPrefix(prefix); err != nil {
+		return err
+	}
+	store.invalidateL1(invalidatePrefix, prefix)
+	return store.publish(invalidatePrefix, prefix)
+}
+
+// DeleteByTag (see InvalidatableStore interface)
+func (store *TieredStore) DeleteByTag(tags ...string) error {
+	// the items cached in l1 by Get have no tags, so the tagged keys are published
+	keys, err := store.L2.taggedKeys(tags...)
+	if err != nil {
+		return err
+	}
+	if err = store.L2.DeleteByTag(tags...); err != nil {
+		return err
+	}
+	store.invalidateL1(invalidateKey, keys...)
+	if len(keys) == 0 {
+		return nil
+	}
+	return store.publish(invalidateKey, keys...)
+}
+
+// Close unsubscribes the invalidation channel
+func (store *TieredStore) Close() error {
+	err := store.pubsub.Close()
+	<-store.done
+	return err
+}
+
+// expireOfL1 caps the expiration in L1 at the expiration in L2, 0 means no expiration in L2.
+// l1NoExpiration is returned if the item expires in neither tier, since 0 means the default expiration of L1.
+func (store *TieredStore) expireOfL1(expire time.Duration) time.Duration {
+	if expire > 0 && (store.l1Expire <= 0 || expire < store.l1Expire) {
+		return expire
+	}
+	if store.l1Expire > 0 {
+		return store.l1Expire
+	}
+	return l1NoExpiration
+}
+
+// bump bumps the versions of the keys being read from L2, the lock must be held
+func (store *TieredStore) bump(op string, values ...string) {
+	for _, v := range values {
+		switch op {
+		case invalidateKey:
+			if f, ok := store.fetches[v]; ok {
+				f.version++
+			}
+		case invalidatePrefix:
+			for key, f := range store.fetches {
+				if strings.HasPrefix(key, v) {
+					f.version++
+				}
+			}
+		}
+	}
+}
+
+func (store *TieredStore) publish(op string, values ...string) error {
+	msg, err := json.Marshal(&invalidation{
+		Origin: store.id,
+		Op:     op,
+		Values: values,
+	})
+	if err != nil {
+		return err
+	}
+	return store.L2.RedisClient.Publish(context.TODO(), store.channel, msg).Err()
+}
+
+func (store *TieredStore) receiving() {
+	defer close(store.done)
+	// the channel is closed when pubsub is closed, go-redis resubscribes after reconnection
+	for msg := range store.pubsub.Channel() {
+		var inv invalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			continue
+		}
+		if inv.Origin == store.id {
+			continue
+		}
+		store.invalidateL1(inv.Op, inv.Values...)
+	}
+}
+
+func (store *TieredStore) invalidateL1(op string, values ...string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.bump(op, values...)
+	switch op {
+	case invalidateKey:
+		for _, key := range values {
+			store.L1.Delete(key)
+		}
+	case invalidatePrefix:
+		l1, ok := store.L1.(InvalidatableStore)
+		if !ok {
+			return
+		}
+		for _, prefix := range values {
+			l1.DeleteByPrefix(prefix)
+		}
+	}
+}
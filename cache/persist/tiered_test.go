@@ -0,0 +1,140 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTieredStore(t *testing.T, mr *miniredis.Miniredis) *TieredStore {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	store, err := NewTieredStore(NewBoundedMemoryStore(time.Minute, 0, EvictLRU), NewRedisStore(client), "", time.Minute)
+	require.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestTieredStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store1 := newTestTieredStore(t, mr)
+	store2 := newTestTieredStore(t, mr)
+
+	value := ""
+	require.Nil(t, store1.Set("test", "v1", time.Minute))
+	assert.Nil(t, store1.L1.Get("test", &value))
+	assert.Equal(t, ErrCacheMiss, store2.L1.Get("test", &value))
+
+	// read from l2, then cached in l1, unless the invalidation of the write arrives while reading
+	assert.Eventually(t, func() bool {
+		return store2.Get("test", &value) == nil && store2.L1.Get("test", &value) == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "v1", value)
+
+	// the write on store1 evicts l1 of store2
+	require.Nil(t, store1.Set("test", "v2", time.Minute))
+	assert.Eventually(t, func() bool {
+		return store2.L1.Get("test", &value) == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, store2.Get("test", &value))
+	assert.Equal(t, "v2", value)
+	// the own invalidation is ignored
+	assert.Nil(t, store1.L1.Get("test", &value))
+
+	require.Nil(t, store1.Delete("test"))
+	assert.Eventually(t, func() bool {
+		return store2.Get("test", &value) == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+
+	// group invalidation
+	require.Nil(t, store1.SetWithTags("/nodes/n1?a=1", "v", time.Minute, "n1"))
+	require.Nil(t, store1.SetWithTags("u1/nodes/n1", "v", time.Minute, "n1"))
+	require.Nil(t, store2.Get("/nodes/n1?a=1", &value))
+	require.Nil(t, store2.Get("u1/nodes/n1", &value))
+
+	require.Nil(t, store1.DeleteByPrefix("/nodes/n1"))
+	require.Nil(t, store1.DeleteByTag("n1"))
+	assert.Eventually(t, func() bool {
+		return store2.L1.Get("/nodes/n1?a=1", &value) == ErrCacheMiss && store2.L1.Get("u1/nodes/n1", &value) == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, ErrCacheMiss, store2.Get("u1/nodes/n1", &value))
+}
+
+// blockingSerializer blocks the deserialization until released
+type blockingSerializer struct {
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSerializer) Serialize(value interface{}) ([]byte, error) {
+	return Serialize(value)
+}
+
+func (s *blockingSerializer) Deserialize(payload []byte, ptr interface{}) error {
+	s.reading <- struct{}{}
+	<-s.release
+	return Deserialize(payload, ptr)
+}
+
+func TestTieredStoreInvalidationWhileReading(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := newTestTieredStore(t, mr)
+	require.Nil(t, store.L2.Set("test", "v1", time.Minute))
+
+	s := &blockingSerializer{reading: make(chan struct{}), release: make(chan struct{})}
+	store.L2.Serializer = s
+	errs := make(chan error)
+	go func() {
+		var value string
+		errs <- store.Get("test", &value)
+	}()
+	<-s.reading
+	// the invalidation from another replica arrives after the value is read from l2
+	store.invalidateL1(invalidateKey, "test")
+	close(s.release)
+	assert.Nil(t, <-errs)
+
+	var value string
+	assert.Equal(t, ErrCacheMiss, store.L1.Get("test", &value))
+	assert.Empty(t, store.fetches)
+}
+
+func TestTieredStoreL1ExpireOfL2(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	// the default expiration of l1 is shorter than the items in l2
+	store, err := NewTieredStore(NewBoundedMemoryStore(50*time.Millisecond, 0, EvictLRU), NewRedisStore(client), "", 0)
+	require.Nil(t, err)
+	defer store.Close()
+	assert.Equal(t, 100*time.Millisecond, store.expireOfL1(100*time.Millisecond))
+	assert.Equal(t, l1NoExpiration, store.expireOfL1(0))
+
+	// the item read from l2 stays in l1 no longer than in l2
+	require.Nil(t, store.L2.Set("test", "v1", 100*time.Millisecond))
+	var value string
+	require.Nil(t, store.Get("test", &value))
+	assert.Nil(t, store.L1.Get("test", &value))
+	assert.Eventually(t, func() bool {
+		return store.L1.Get("test", &value) == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+
+	// the item without ttl in l2 never expires in l1
+	require.Nil(t, store.L2.Set("forever", "v2", 0))
+	require.Nil(t, store.Get("forever", &value))
+	assert.Equal(t, "v2", value)
+	require.Nil(t, store.Set("forever2", "v3", 0))
+	time.Sleep(100 * time.Millisecond)
+	value = ""
+	assert.Nil(t, store.L1.Get("forever", &value))
+	assert.Equal(t, "v2", value)
+	assert.Nil(t, store.L1.Get("forever2", &value))
+	assert.Equal(t, "v3", value)
+
+	// the missing key
+	assert.Equal(t, ErrCacheMiss, store.Get("missing", &value))
+}
@@ -1,13 +1,27 @@
 package cache
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha1"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	headerETag            = "ETag"
+	headerLastModified    = "Last-Modified"
+	headerIfNoneMatch     = "If-None-Match"
+	headerIfModifiedSince = "If-Modified-Since"
+)
+
 func init() {
 	gob.Register(&ResponseCache{})
 }
@@ -17,19 +31,63 @@ type ResponseCache struct {
 	Status int
 	Header http.Header
 	Data   []byte
+
+	// ETag the entity tag of the response, it is generated by the data if the handler does not set one
+	ETag string
+	// LastModified the time when the response is cached if the handler does not set one
+	LastModified time.Time
+
+	// ExpireAt the response is stale after this time but kept in the store for stale-while-revalidate
+	// and stale-if-error, zero means the response is fresh as long as it is in the store
+	ExpireAt time.Time
+
+	// Date the time when the response is generated
+	Date time.Time
+	// Vary the request headers which the response varies on, only recorded when respecting Cache-Control.
+	// A response without status and with Vary is a marker, the variants are stored under the keys with the header values
+	Vary []string
+	// VaryKey the key of the variant which the response is stored under
+	VaryKey string
+}
+
+func (c *ResponseCache) isVaryMarker() bool {
+	return c.Status == 0 && len(c.Vary) > 0
+}
+
+func (c *ResponseCache) isFresh(now time.Time) bool {
+	return c.ExpireAt.IsZero() || now.Before(c.ExpireAt)
+}
+
+// isStaleWithin returns whether the response has been stale for no longer than window
+func (c *ResponseCache) isStaleWithin(now time.Time, window time.Duration) bool {
+	return window > 0 && !c.ExpireAt.IsZero() && !now.After(c.ExpireAt.Add(window))
 }
 
 func (c *ResponseCache) fillWithCacheWriter(cacheWriter *responseCacheWriter, withoutHeader bool, withoutHeaderIgnore []string) {
-	c.Status = cacheWriter.Status()
-	c.Data = cacheWriter.body.Bytes()
+	c.fill(cacheWriter.Status(), cacheWriter.Header(), cacheWriter.body.Bytes(), withoutHeader, withoutHeaderIgnore)
+}
+
+func (c *ResponseCache) fill(status int, header http.Header, data []byte, withoutHeader bool, withoutHeaderIgnore []string) {
+	c.Status = status
+	c.Data = data
+	c.Date = time.Now()
+	c.ETag = header.Get(headerETag)
+	if c.ETag == "" {
+		c.ETag = generateETag(c.Data)
+	}
+	lastModified, err := http.ParseTime(header.Get(headerLastModified))
+	if err != nil {
+		lastModified = time.Now()
+	}
+	c.LastModified = lastModified.UTC().Truncate(time.Second)
 	if !withoutHeader {
-		c.Header = cacheWriter.Header().Clone()
+		c.Header = header.Clone()
 	} else {
 		if c.Header == nil {
 			c.Header = http.Header{}
 		}
 		for _, k := range withoutHeaderIgnore {
-			c.Header.Set(k, cacheWriter.Header().Get(k))
+			c.Header.Set(k, header.Get(k))
 		}
 	}
 }
@@ -39,21 +97,139 @@ type responseCacheWriter struct {
 	gin.ResponseWriter
 
 	body bytes.Buffer
+	// buffered holds the response back from the client until flush is called
+	buffered bool
 }
 
 func (w *responseCacheWriter) Write(b []byte) (int, error) {
 	w.body.Write(b)
+	if w.buffered {
+		return len(b), nil
+	}
 	return w.ResponseWriter.Write(b)
 }
 
 func (w *responseCacheWriter) WriteString(s string) (int, error) {
 	w.body.WriteString(s)
+	if w.buffered {
+		return len(s), nil
+	}
 	return w.ResponseWriter.WriteString(s)
 }
 
+func (w *responseCacheWriter) WriteHeaderNow() {
+	if !w.buffered {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *responseCacheWriter) Flush() {
+	if !w.buffered {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// flush sends the buffered response to the client
+func (w *responseCacheWriter) flush() error {
+	if !w.buffered {
+		return nil
+	}
+	w.buffered = false
+	if w.body.Len() == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.body.Bytes())
+	return err
+}
+
+// detachedWriter is the response writer of background refreshing, nothing is sent to any client
+type detachedWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func newDetachedWriter() *detachedWriter {
+	return &detachedWriter{
+		header: http.Header{},
+		status: http.StatusOK,
+		size:   -1,
+	}
+}
+
+func (w *detachedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *detachedWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *detachedWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *detachedWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(b)
+	return len(b), nil
+}
+
+func (w *detachedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *detachedWriter) Status() int {
+	return w.status
+}
+
+func (w *detachedWriter) Size() int {
+	return w.size
+}
+
+func (w *detachedWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *detachedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("cache: hijack is not supported when refreshing")
+}
+
+func (w *detachedWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *detachedWriter) Flush() {}
+
+func (w *detachedWriter) Pusher() http.Pusher {
+	return nil
+}
+
 func replyWithCache(c *gin.Context, cfg *Config, respCache *ResponseCache) {
 	cfg.beforeReplyWithCacheCallback(c, respCache)
 
+	// the response cached by old versions has no etag
+	etag := respCache.ETag
+	if etag == "" {
+		etag = generateETag(respCache.Data)
+	}
+
+	if isNotModified(c.Request, etag, respCache.LastModified) {
+		c.Writer.Header().Set(headerETag, etag)
+		if !respCache.LastModified.IsZero() {
+			c.Writer.Header().Set(headerLastModified, respCache.LastModified.Format(http.TimeFormat))
+		}
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		c.Abort()
+		return
+	}
+
 	c.Writer.WriteHeader(respCache.Status)
 
 	if !cfg.withoutHeader {
@@ -68,6 +244,11 @@ func replyWithCache(c *gin.Context, cfg *Config, respCache *ResponseCache) {
 		}
 	}
 
+	c.Writer.Header().Set(headerETag, etag)
+	if !respCache.LastModified.IsZero() {
+		c.Writer.Header().Set(headerLastModified, respCache.LastModified.Format(http.TimeFormat))
+	}
+
 	if _, err := c.Writer.Write(respCache.Data); err != nil {
 		cfg.logger.Errorf("write response error: %s", err)
 	}
@@ -75,3 +256,38 @@ func replyWithCache(c *gin.Context, cfg *Config, respCache *ResponseCache) {
 	// abort handler chain and return directly
 	c.Abort()
 }
+
+func generateETag(data []byte) string {
+	sum := sha1.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// isNotModified evaluates the conditional request headers, If-Modified-Since is ignored when If-None-Match presents
+func isNotModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get(headerIfNoneMatch); inm != "" {
+		return matchETag(inm, etag)
+	}
+	if ims := req.Header.Get(headerIfModifiedSince); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// matchETag uses the weak comparison of etags
+func matchETag(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
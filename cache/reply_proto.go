@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The protobuf encoding of ResponseCache is hand-maintained, there is no .proto file to generate it from.
+// It is the wire format of the following schema, so that the services in other languages are able to
+// read the responses cached in a shared store. The times are unix nanoseconds, zero means the zero time.
+// The field numbers must never be changed or reused.
+//
+//	message ResponseCache {
+//	    int32 Status           = 1;
+//	    repeated Header Header = 2;
+//	    bytes Data             = 3;
+//	    string ETag            = 4;
+//	    int64 LastModified     = 5;
+//	    int64 ExpireAt         = 6;
+//	    int64 Date             = 7;
+//	    repeated string Vary   = 8;
+//	    string VaryKey         = 9;
+//	}
+//
+//	message Header {
+//	    string Key             = 1;
+//	    repeated string Values = 2;
+//	}
+const (
+	fieldStatus       protowire.Number = 1
+	fieldHeader       protowire.Number = 2
+	fieldData         protowire.Number = 3
+	fieldETag         protowire.Number = 4
+	fieldLastModified protowire.Number = 5
+	fieldExpireAt     protowire.Number = 6
+	fieldDate         protowire.Number = 7
+	fieldVary         protowire.Number = 8
+	fieldVaryKey      protowire.Number = 9
+
+	fieldHeaderKey    protowire.Number = 1
+	fieldHeaderValues protowire.Number = 2
+)
+
+var errInvalidProto = errors.New("cache: invalid protobuf response cache")
+
+// Marshal encodes the response cache in protobuf format, see the schema above
+func (c *ResponseCache) Marshal() ([]byte, error) {
+	var b []byte
+	if c.Status != 0 {
+		b = protowire.AppendTag(b, fieldStatus, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(c.Status)))
+	}
+
+	keys := make([]string, 0, len(c.Header))
+	for k := range c.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var h []byte
+		h = protowire.AppendTag(h, fieldHeaderKey, protowire.BytesType)
+		h = protowire.AppendString(h, k)
+		for _, v := range c.Header[k] {
+			h = protowire.AppendTag(h, fieldHeaderValues, protowire.BytesType)
+			h = protowire.AppendString(h, v)
+		}
+		b = protowire.AppendTag(b, fieldHeader, protowire.BytesType)
+		b = protowire.AppendBytes(b, h)
+	}
+
+	if len(c.Data) > 0 {
+		b = protowire.AppendTag(b, fieldData, protowire.BytesType)
+		b = protowire.AppendBytes(b, c.Data)
+	}
+	if c.ETag != "" {
+		b = protowire.AppendTag(b, fieldETag, protowire.BytesType)
+		b = protowire.AppendString(b, c.ETag)
+	}
+	b = appendTime(b, fieldLastModified, c.LastModified)
+	b = appendTime(b, fieldExpireAt, c.ExpireAt)
+	b = appendTime(b, fieldDate, c.Date)
+	for _, v := range c.Vary {
+		b = protowire.AppendTag(b, fieldVary, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	}
+	if c.VaryKey != "" {
+		b = protowire.AppendTag(b, fieldVaryKey, protowire.BytesType)
+		b = protowire.AppendString(b, c.VaryKey)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes the response cache in protobuf format, see the schema above
+func (c *ResponseCache) Unmarshal(b []byte) error {
+	*c = ResponseCache{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+
+		switch {
+		case num == fieldStatus && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			c.Status = int(int64(v))
+			b = b[n:]
+		case num == fieldHeader && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			if err := c.unmarshalHeader(v); err != nil {
+				return err
+			}
+			b = b[n:]
+		case num == fieldData && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			c.Data = append([]byte{}, v...)
+			b = b[n:]
+		case (num == fieldETag || num == fieldVary || num == fieldVaryKey) && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			switch num {
+			case fieldETag:
+				c.ETag = v
+			case fieldVary:
+				c.Vary = append(c.Vary, v)
+			default:
+				c.VaryKey = v
+			}
+			b = b[n:]
+		case (num == fieldLastModified || num == fieldExpireAt || num == fieldDate) && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			t := time.Unix(0, int64(v))
+			switch num {
+			case fieldLastModified:
+				c.LastModified = t
+			case fieldExpireAt:
+				c.ExpireAt = t
+			default:
+				c.Date = t
+			}
+			b = b[n:]
+		default:
+			// skip unknown fields
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func (c *ResponseCache) unmarshalHeader(b []byte) error {
+	var key string
+	var values []string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+		if (num == fieldHeaderKey || num == fieldHeaderValues) && typ == protowire.BytesType {
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return errInvalidProto
+			}
+			if num == fieldHeaderKey {
+				key = v
+			} else {
+				values = append(values, v)
+			}
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+	}
+	if c.Header == nil {
+		c.Header = http.Header{}
+	}
+	c.Header[key] = append(c.Header[key], values...)
+	return nil
+}
+
+func appendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(t.UnixNano()))
+}
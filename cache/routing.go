@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
+)
+
+// MCacheRouting is the counterpart of MCache for fasthttp-routing,
+// user must pass WithRoutingCacheStrategyByRequest to describe the way to generate cache key
+func MCacheRouting(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, opts ...Option) routing.Handler {
+	cfg := newConfigByOpts(opts...)
+	return _routingCache(defaultCacheStore, defaultExpire, cfg, true, nil)
+}
+
+// MCacheRoutingByRequestURI a shortcut function for caching response by uri
+func MCacheRoutingByRequestURI(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, opts ...Option) routing.Handler {
+	cfg := newConfigByOpts(opts...)
+	cfg.setRoutingRequestURI()
+	return _routingCache(defaultCacheStore, defaultExpire, cfg, true, nil)
+}
+
+// MCacheRoutingByRequestPath a shortcut function for caching response by url path, means will discard the query params
+func MCacheRoutingByRequestPath(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, opts ...Option) routing.Handler {
+	opts = append(opts, WithRoutingCacheStrategyByRequest(getRoutingRequestPath))
+	return MCacheRouting(defaultCacheStore, defaultExpire, opts...)
+}
+
+// WCacheRouting is the counterpart of WCache for fasthttp-routing,
+// user must pass WithRoutingCacheStrategyByRequest to describe the way to generate cache key
+func WCacheRouting(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, handle routing.Handler, opts ...Option) routing.Handler {
+	cfg := newConfigByOpts(opts...)
+	return _routingCache(defaultCacheStore, defaultExpire, cfg, false, handle)
+}
+
+// WCacheRoutingByRequestURI a shortcut function for caching response by uri
+func WCacheRoutingByRequestURI(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, handle routing.Handler, opts ...Option) routing.Handler {
+	cfg := newConfigByOpts(opts...)
+	cfg.setRoutingRequestURI()
+	return _routingCache(defaultCacheStore, defaultExpire, cfg, false, handle)
+}
+
+// WCacheRoutingByRequestPath a shortcut function for caching response by url path, means will discard the query params
+func WCacheRoutingByRequestPath(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, handle routing.Handler, opts ...Option) routing.Handler {
+	opts = append(opts, WithRoutingCacheStrategyByRequest(getRoutingRequestPath))
+	return WCacheRouting(defaultCacheStore, defaultExpire, handle, opts...)
+}
+
+func getRoutingRequestPath(c *routing.Context) (Strategy, bool) {
+	return Strategy{
+		CacheKey: string(c.Path()),
+	}, true
+}
+
+func _routingCache(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, cfg *Config, isMiddleware bool, handle routing.Handler) routing.Handler {
+	if cfg.getRoutingCacheStrategyByRequest == nil {
+		panic("cache strategy is nil")
+	}
+
+	core := newCacheCore(defaultCacheStore, defaultExpire, cfg, true)
+
+	run := handle
+	if isMiddleware {
+		run = func(c *routing.Context) error { return c.Next() }
+	}
+
+	return func(c *routing.Context) error {
+		return core.serve(&routingContext{c: c, cfg: cfg, handle: run})
+	}
+}
+
+// routingContext adapts the fasthttp-routing context to the cache pipeline.
+// The response is not sent until the handlers return, so it is recorded in the context.
+type routingContext struct {
+	c      *routing.Context
+	cfg    *Config
+	handle routing.Handler
+	req    *http.Request
+}
+
+func (a *routingContext) strategy() (Strategy, bool) {
+	return a.cfg.getRoutingCacheStrategyByRequest(a.c)
+}
+
+func (a *routingContext) value(key string) string {
+	v, _ := a.c.Get(key).(string)
+	return v
+}
+
+func (a *routingContext) request() *http.Request {
+	if a.req == nil {
+		a.req = newRoutingRequest(a.c)
+	}
+	return a.req
+}
+
+func (a *routingContext) route() string {
+	return statsOtherRoute
+}
+
+func (a *routingContext) warming() bool {
+	return IsReplayed(a.c.RequestCtx)
+}
+
+func (a *routingContext) next() error {
+	return a.handle(a.c)
+}
+
+func (a *routingContext) record() {}
+
+func (a *routingContext) run() error {
+	return a.next()
+}
+
+func (a *routingContext) response() (int, http.Header, []byte) {
+	return a.c.Response.StatusCode(), routingResponseHeader(&a.c.Response), append([]byte{}, a.c.Response.Body()...)
+}
+
+func (a *routingContext) aborted() bool {
+	return false
+}
+
+func (a *routingContext) validate(etag string, lastModified time.Time) {
+	a.c.Response.Header.Set(headerETag, etag)
+	a.c.Response.Header.Set(headerLastModified, lastModified.Format(http.TimeFormat))
+}
+
+func (a *routingContext) discard() {
+	a.c.Response.Reset()
+}
+
+func (a *routingContext) flush() error {
+	return nil
+}
+
+func (a *routingContext) reply(respCache *ResponseCache) {
+	replyWithRoutingCache(a.c, a.cfg, a.request(), respCache)
+}
+
+func (a *routingContext) callback(kind statsKind) {
+	a.cfg.routingCallbacks.call(kind, a.c)
+}
+
+// refresh replays the request to the router in background, since a routing context can not outlive the request,
+// and the route parameters and the rest of the handlers can not be copied to another one.
+// The replayed request skips reading the cache like the ones of the warmer, and runs the whole handler chain,
+// so the side effects of the middlewares happen again, which they can skip by IsReplayed.
+func (a *routingContext) refresh(_ func(c cacheContext), done func()) {
+	router := a.c.Router()
+	if router == nil {
+		done()
+		return
+	}
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&a.c.Request, a.c.RemoteAddr(), nil)
+	ctx.SetUserValue(warmingUserValue, true)
+	go func() {
+		defer done()
+		router.HandleRequest(ctx)
+	}()
+}
+
+func replyWithRoutingCache(c *routing.Context, cfg *Config, req *http.Request, respCache *ResponseCache) {
+	if cfg.routingCallbacks.BeforeReply != nil {
+		cfg.routingCallbacks.BeforeReply(c, respCache)
+	}
+
+	// the response cached by old versions has no etag
+	etag := respCache.ETag
+	if etag == "" {
+		etag = generateETag(respCache.Data)
+	}
+
+	if isNotModified(req, etag, respCache.LastModified) {
+		c.Response.Header.Set(headerETag, etag)
+		if !respCache.LastModified.IsZero() {
+			c.Response.Header.Set(headerLastModified, respCache.LastModified.Format(http.TimeFormat))
+		}
+		c.SetStatusCode(http.StatusNotModified)
+		c.Response.ResetBody()
+		c.Abort()
+		return
+	}
+
+	c.SetStatusCode(respCache.Status)
+
+	if !cfg.withoutHeader {
+		for key, values := range respCache.Header {
+			for _, val := range values {
+				c.Response.Header.Set(key, val)
+			}
+		}
+	} else {
+		for _, key := range cfg.withoutHeaderIgnore {
+			c.Response.Header.Set(key, respCache.Header.Get(key))
+		}
+	}
+
+	c.Response.Header.Set(headerETag, etag)
+	if !respCache.LastModified.IsZero() {
+		c.Response.Header.Set(headerLastModified, respCache.LastModified.Format(http.TimeFormat))
+	}
+
+	c.SetBody(respCache.Data)
+
+	// abort handler chain and return directly
+	c.Abort()
+}
+
+// newRoutingRequest converts the method and headers of the fasthttp request
+func newRoutingRequest(c *routing.Context) *http.Request {
+	header := http.Header{}
+	c.Request.Header.VisitAll(func(k, v []byte) {
+		header.Add(string(k), string(v))
+	})
+	return &http.Request{
+		Method:     string(c.Method()),
+		RequestURI: string(c.RequestURI()),
+		Header:     header,
+	}
+}
+
+func routingResponseHeader(resp *fasthttp.Response) http.Header {
+	header := http.Header{}
+	resp.Header.VisitAll(func(k, v []byte) {
+		header.Add(string(k), string(v))
+	})
+	return header
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	routing "github.com/qiangxue/fasthttp-routing"
+)
+
+// GetRoutingCacheStrategyByRequest is the counterpart of GetCacheStrategyByRequest for fasthttp-routing.
+type GetRoutingCacheStrategyByRequest func(c *routing.Context) (Strategy, bool)
+
+// WithRoutingCacheStrategyByRequest set up the custom strategy by per request for fasthttp-routing
+func WithRoutingCacheStrategyByRequest(getCacheStrategyByRequest GetRoutingCacheStrategyByRequest) Option {
+	return func(c *Config) {
+		if getCacheStrategyByRequest != nil {
+			c.getRoutingCacheStrategyByRequest = getCacheStrategyByRequest
+		}
+	}
+}
+
+// RoutingCallbacks the callbacks of fasthttp-routing, which are called on the same events as the gin callbacks.
+// The nil callbacks are ignored.
+type RoutingCallbacks struct {
+	// OnHit see WithOnHitCache
+	OnHit func(c *routing.Context)
+	// OnMiss see WithOnMissCache
+	OnMiss func(c *routing.Context)
+	// OnHitStale see WithOnHitStaleCache
+	OnHitStale func(c *routing.Context)
+	// OnStaleIfError see WithOnStaleIfError
+	OnStaleIfError func(c *routing.Context)
+	// OnShareSingleFlight see WithOnShareSingleFlight
+	OnShareSingleFlight func(c *routing.Context)
+	// BeforeReply see WithBeforeReplyWithCache
+	BeforeReply func(c *routing.Context, cache *ResponseCache)
+}
+
+// WithRoutingCallbacks set up the callbacks of fasthttp-routing
+func WithRoutingCallbacks(cbs RoutingCallbacks) Option {
+	return func(c *Config) {
+		c.routingCallbacks = cbs
+	}
+}
+
+func (cbs *RoutingCallbacks) call(kind statsKind, c *routing.Context) {
+	var cb func(c *routing.Context)
+	switch kind {
+	case statsHit:
+		cb = cbs.OnHit
+	case statsMiss:
+		cb = cbs.OnMiss
+	case statsStaleHit:
+		cb = cbs.OnHitStale
+	case statsStaleIfError:
+		cb = cbs.OnStaleIfError
+	case statsSharedFlight:
+		cb = cbs.OnShareSingleFlight
+	}
+	if cb != nil {
+		cb(c)
+	}
+}
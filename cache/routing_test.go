@@ -0,0 +1,195 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
+)
+
+func mockRoutingRequest(router *routing.Router, uri string, headers ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(http.MethodGet)
+	ctx.Request.SetRequestURI(uri)
+	for i := 0; i+1 < len(headers); i += 2 {
+		ctx.Request.Header.Set(headers[i], headers[i+1])
+	}
+	router.HandleRequest(ctx)
+	return ctx
+}
+
+func TestRoutingMiddleware(t *testing.T) {
+	var hit, miss, calls int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	router := routing.New()
+	router.Get("/cache", MCacheRoutingByRequestURI(memoryStore, 3*time.Second,
+		WithRoutingCallbacks(RoutingCallbacks{
+			OnHit: func(c *routing.Context) {
+				atomic.AddInt32(&hit, 1)
+			},
+			OnMiss: func(c *routing.Context) {
+				atomic.AddInt32(&miss, 1)
+			},
+		})), func(c *routing.Context) error {
+		atomic.AddInt32(&calls, 1)
+		c.Response.Header.Set(headerOtherKey, headerOtherVal)
+		c.SetContentType("text/plain")
+		return c.WriteData("uid:" + string(c.QueryArgs().Peek("uid")))
+	})
+
+	ctx1 := mockRoutingRequest(router, "/cache?uid=1")
+	ctx2 := mockRoutingRequest(router, "/cache?uid=1")
+	ctx3 := mockRoutingRequest(router, "/cache?uid=2")
+
+	assert.Equal(t, "uid:1", string(ctx1.Response.Body()))
+	assert.Equal(t, "uid:1", string(ctx2.Response.Body()))
+	assert.Equal(t, "uid:2", string(ctx3.Response.Body()))
+	assert.Equal(t, http.StatusOK, ctx2.Response.StatusCode())
+	assert.Equal(t, headerOtherVal, string(ctx2.Response.Header.Peek(headerOtherKey)))
+	assert.Equal(t, "text/plain", string(ctx2.Response.Header.ContentType()))
+	assert.NotEmpty(t, ctx2.Response.Header.Peek(headerETag))
+	// the first response has the validators
+	assert.Equal(t, ctx2.Response.Header.Peek(headerETag), ctx1.Response.Header.Peek(headerETag))
+	assert.Equal(t, ctx2.Response.Header.Peek(headerLastModified), ctx1.Response.Header.Peek(headerLastModified))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hit))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&miss))
+
+	// conditional request
+	ctx4 := mockRoutingRequest(router, "/cache?uid=1", headerIfNoneMatch, string(ctx2.Response.Header.Peek(headerETag)))
+	assert.Equal(t, http.StatusNotModified, ctx4.Response.StatusCode())
+	assert.Empty(t, ctx4.Response.Body())
+}
+
+func TestRoutingWrapper(t *testing.T) {
+	var calls, shared int32
+	var fail int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	router := routing.New()
+	router.Get("/cache", WCacheRoutingByRequestPath(memoryStore, time.Second, func(c *routing.Context) error {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			return routing.NewHTTPError(http.StatusInternalServerError, "error")
+		}
+		time.Sleep(100 * time.Millisecond)
+		return c.WriteData(fmt.Sprintf("value:%d", atomic.LoadInt32(&calls)))
+	}, WithStaleIfError(2*time.Second), WithRoutingCallbacks(RoutingCallbacks{
+		OnShareSingleFlight: func(c *routing.Context) {
+			atomic.AddInt32(&shared, 1)
+		},
+	})))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := mockRoutingRequest(router, fmt.Sprintf("/cache?i=%d", i))
+			assert.Equal(t, "value:1", string(ctx.Response.Body()))
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(9), atomic.LoadInt32(&shared))
+
+	// the error is not cached, and the stale cache is replied
+	atomic.StoreInt32(&fail, 1)
+	time.Sleep(1100 * time.Millisecond)
+	ctx := mockRoutingRequest(router, "/cache")
+	assert.Equal(t, http.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "value:1", string(ctx.Response.Body()))
+
+	time.Sleep(2100 * time.Millisecond)
+	ctx = mockRoutingRequest(router, "/cache")
+	assert.Equal(t, http.StatusInternalServerError, ctx.Response.StatusCode())
+	assert.Equal(t, "error", string(ctx.Response.Body()))
+}
+
+func TestRoutingStrategy(t *testing.T) {
+	var calls int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	router := routing.New()
+	router.Get("/cache/<name>", WCacheRouting(memoryStore, time.Minute, func(c *routing.Context) error {
+		atomic.AddInt32(&calls, 1)
+		if c.Param("name") == "err" {
+			return errors.New("failed")
+		}
+		return c.WriteData(c.Param("name"))
+	}, WithPrefixKey("prefix:"), WithRoutingCacheStrategyByRequest(func(c *routing.Context) (Strategy, bool) {
+		return Strategy{
+			CacheKey: c.Param("name"),
+		}, c.Param("name") != "nocache"
+	})))
+
+	assert.Equal(t, "a", string(mockRoutingRequest(router, "/cache/a").Response.Body()))
+	assert.Equal(t, "a", string(mockRoutingRequest(router, "/cache/a").Response.Body()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	value := &ResponseCache{}
+	assert.Nil(t, memoryStore.Get("prefix:a", &value))
+
+	mockRoutingRequest(router, "/cache/nocache")
+	mockRoutingRequest(router, "/cache/nocache")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	assert.Equal(t, http.StatusInternalServerError, mockRoutingRequest(router, "/cache/err").Response.StatusCode())
+	assert.Equal(t, http.StatusInternalServerError, mockRoutingRequest(router, "/cache/err").Response.StatusCode())
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+}
+
+func TestRoutingStaleWhileRevalidate(t *testing.T) {
+	var calls, stale int32
+	memoryStore := persist.NewInMemoryStore(1 * time.Minute)
+	router := routing.New()
+	var logs, replays int32
+	router.Use(func(c *routing.Context) error {
+		if IsReplayed(c.RequestCtx) {
+			atomic.AddInt32(&replays, 1)
+		} else {
+			atomic.AddInt32(&logs, 1)
+		}
+		c.Response.Header.Set(headerOtherKey, fmt.Sprint(atomic.LoadInt32(&calls)+1))
+		return c.Next()
+	})
+	router.Get("/cache", MCacheRoutingByRequestURI(memoryStore, time.Second,
+		WithStaleWhileRevalidate(2*time.Second),
+		WithRoutingCallbacks(RoutingCallbacks{
+			OnHitStale: func(c *routing.Context) {
+				atomic.AddInt32(&stale, 1)
+			},
+		})), func(c *routing.Context) error {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return c.WriteData(fmt.Sprintf("value:%d", n))
+	})
+
+	assert.Equal(t, "value:1", string(mockRoutingRequest(router, "/cache").Response.Body()))
+	time.Sleep(1100 * time.Millisecond)
+
+	// the stale cache is replied without waiting for the refresh
+	start := time.Now()
+	ctx := mockRoutingRequest(router, "/cache")
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, "value:1", string(ctx.Response.Body()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stale))
+
+	// the refresh runs the whole handler chain in background
+	assert.Eventually(t, func() bool {
+		ctx := mockRoutingRequest(router, "/cache")
+		return string(ctx.Response.Body()) == "value:2" && string(ctx.Response.Header.Peek(headerOtherKey)) == "2"
+	}, time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	// the middleware knows the refresh is replayed
+	assert.Equal(t, int32(1), atomic.LoadInt32(&replays))
+	assert.Greater(t, atomic.LoadInt32(&logs), int32(2))
+}
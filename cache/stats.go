@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
+)
+
+type statsKind int
+
+const (
+	statsHit statsKind = iota
+	statsStaleHit
+	statsStaleIfError
+	statsMiss
+	statsSharedFlight
+	statsSetError
+	statsBytes
+	numStatsKinds
+)
+
+// statsMetrics the prometheus metric name suffixes and helps of stats kinds
+var statsMetrics = [numStatsKinds][2]string{
+	{"hits_total", "The number of requests replied with fresh cache."},
+	{"stale_hits_total", "The number of requests replied with stale cache while revalidating."},
+	{"stale_if_errors_total", "The number of requests replied with stale cache because the backend failed."},
+	{"misses_total", "The number of requests missing the cache."},
+	{"shared_flights_total", "The number of requests sharing the backend response of another request."},
+	{"set_errors_total", "The number of failures setting the cache store."},
+	{"stored_bytes_total", "The bytes of responses set to the cache store."},
+}
+
+type statsCounters [numStatsKinds]int64
+
+// StatsCounters the snapshot of cache statistics
+type StatsCounters struct {
+	Hits          int64 `json:"hits"`
+	StaleHits     int64 `json:"staleHits"`
+	StaleIfErrors int64 `json:"staleIfErrors"`
+	Misses        int64 `json:"misses"`
+	SharedFlights int64 `json:"sharedFlights"`
+	SetErrors     int64 `json:"setErrors"`
+	StoredBytes   int64 `json:"storedBytes"`
+
+	// Size the current bytes in the store, only reported by the stores knowing their size
+	Size int64 `json:"size,omitempty"`
+	// Evictions the number of items evicted by the store, only reported by the stores with capacity
+	Evictions int64 `json:"evictions,omitempty"`
+}
+
+// StatsSnapshot the statistics of all routes and stores
+type StatsSnapshot struct {
+	Routes map[string]StatsCounters `json:"routes"`
+	Stores map[string]StatsCounters `json:"stores"`
+}
+
+// statsOtherRoute the route label of the requests without a route template, which keeps the label values bounded
+const statsOtherRoute = "other"
+
+// Stats collects the statistics of cache by route and by store, it is enabled by WithStats.
+// A nil Stats collects nothing.
+type Stats struct {
+	routes map[string]*statsCounters
+	stores map[string]*storeCounters
+	lock   sync.RWMutex
+}
+
+// storeCounters the counters of a named store, the store is the first one counted under the name
+type storeCounters struct {
+	statsCounters
+	store persist.CacheStore
+}
+
+// NewStats creates a stats collector
+func NewStats() *Stats {
+	return &Stats{
+		routes: map[string]*statsCounters{},
+		stores: map[string]*storeCounters{},
+	}
+}
+
+// add counts the event under the route and the store name, the store is not counted if it is unnamed
+func (s *Stats) add(route, storeName string, store persist.CacheStore, kind statsKind, delta int64) {
+	if s == nil {
+		return
+	}
+	rc, sc := s.counters(route, storeName, store)
+	atomic.AddInt64(&rc[kind], delta)
+	if sc != nil {
+		atomic.AddInt64(&sc.statsCounters[kind], delta)
+	}
+}
+
+func (s *Stats) counters(route, storeName string, store persist.CacheStore) (*statsCounters, *storeCounters) {
+	s.lock.RLock()
+	rc, rok := s.routes[route]
+	sc, sok := s.stores[storeName]
+	s.lock.RUnlock()
+	if rok && (sok || storeName == "") {
+		return rc, sc
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if rc = s.routes[route]; rc == nil {
+		rc = &statsCounters{}
+		s.routes[route] = rc
+	}
+	if storeName == "" {
+		return rc, nil
+	}
+	if sc = s.stores[storeName]; sc == nil {
+		sc = &storeCounters{store: store}
+		s.stores[storeName] = sc
+	}
+	return rc, sc
+}
+
+// Snapshot returns the current statistics
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	snapshot := StatsSnapshot{
+		Routes: map[string]StatsCounters{},
+		Stores: map[string]StatsCounters{},
+	}
+	for route, c := range s.routes {
+		snapshot.Routes[route] = c.snapshot()
+	}
+	for name, c := range s.stores {
+		counters := c.snapshot()
+		switch store := c.store.(type) {
+		case *persist.BoundedMemoryStore:
+			st := store.Stats()
+			counters.Size = st.Bytes
+			counters.Evictions = int64(st.Evictions)
+		case *persist.DiskStore:
+			counters.Size = store.Size()
+			counters.Evictions = int64(store.Evictions())
+		}
+		snapshot.Stores[name] = counters
+	}
+	return snapshot
+}
+
+func (c *statsCounters) snapshot() StatsCounters {
+	return StatsCounters{
+		Hits:          atomic.LoadInt64(&c[statsHit]),
+		StaleHits:     atomic.LoadInt64(&c[statsStaleHit]),
+		StaleIfErrors: atomic.LoadInt64(&c[statsStaleIfError]),
+		Misses:        atomic.LoadInt64(&c[statsMiss]),
+		SharedFlights: atomic.LoadInt64(&c[statsSharedFlight]),
+		SetErrors:     atomic.LoadInt64(&c[statsSetError]),
+		StoredBytes:   atomic.LoadInt64(&c[statsBytes]),
+	}
+}
+
+func (c StatsCounters) values() [numStatsKinds]int64 {
+	return [numStatsKinds]int64{c.Hits, c.StaleHits, c.StaleIfErrors, c.Misses, c.SharedFlights, c.SetErrors, c.StoredBytes}
+}
+
+// WritePrometheus writes the statistics in prometheus text format,
+// the metrics are named cache_route_* with label route and cache_store_* with label store
+func (s *Stats) WritePrometheus(w io.Writer) error {
+	snapshot := s.Snapshot()
+	routes := sortedKeys(snapshot.Routes)
+	stores := sortedKeys(snapshot.Stores)
+
+	var b strings.Builder
+	for kind, metric := range statsMetrics {
+		name := "cache_route_" + metric[0]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, metric[1], name)
+		for _, route := range routes {
+			fmt.Fprintf(&b, "%s{route=\"%s\"} %d\n", name, escapeLabel(route), snapshot.Routes[route].values()[kind])
+		}
+	}
+	for kind, metric := range statsMetrics {
+		name := "cache_store_" + metric[0]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, metric[1], name)
+		for _, store := range stores {
+			fmt.Fprintf(&b, "%s{store=\"%s\"} %d\n", name, escapeLabel(store), snapshot.Stores[store].values()[kind])
+		}
+	}
+	b.WriteString("# HELP cache_store_size_bytes The current bytes in the cache store.\n# TYPE cache_store_size_bytes gauge\n")
+	for _, store := range stores {
+		fmt.Fprintf(&b, "cache_store_size_bytes{store=\"%s\"} %d\n", escapeLabel(store), snapshot.Stores[store].Size)
+	}
+	b.WriteString("# HELP cache_store_evictions_total The number of items evicted by the cache store.\n# TYPE cache_store_evictions_total counter\n")
+	for _, store := range stores {
+		fmt.Fprintf(&b, "cache_store_evictions_total{store=\"%s\"} %d\n", escapeLabel(store), snapshot.Stores[store].Evictions)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// PrometheusHandler serves the statistics in prometheus text format
+func (s *Stats) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WritePrometheus(w)
+	})
+}
+
+// JSONHandler serves the statistics in json format, see StatsSnapshot
+func (s *Stats) JSONHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.Snapshot())
+	})
+}
+
+func sortedKeys(m map[string]StatsCounters) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
+)
+
+func TestStats(t *testing.T) {
+	stats := NewStats()
+	boundedStore := persist.NewBoundedMemoryStore(time.Minute, 1024, persist.EvictLRU)
+	memoryStore := persist.NewInMemoryStore(time.Minute)
+
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.GET("/nodes/:name", WCacheByRequestURI(boundedStore, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "node")
+	}, WithStats(stats, "", "bounded")))
+	engine.GET("/apps", WCacheByRequestURI(memoryStore, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "apps")
+	}, WithStats(stats, "apps", "memory")))
+	// the store of a strategy is counted under its own name
+	engine.GET("/other", WCache(boundedStore, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "other")
+	}, WithStats(stats, "", "bounded"), WithCacheStrategyByRequest(func(c *gin.Context) (Strategy, bool) {
+		return Strategy{CacheKey: c.Request.RequestURI, CacheStore: memoryStore, CacheStoreName: "memory"}, true
+	})))
+	engine.NoRoute(WCacheByRequestURI(memoryStore, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusNotFound, "none")
+	}, WithStats(stats, "", "")))
+	serve := func(url string) {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+	serve("/nodes/n1")
+	serve("/nodes/n1")
+	serve("/nodes/n2")
+	serve("/apps")
+	serve("/other")
+	serve("/none/1")
+	serve("/none/2")
+
+	router := routing.New()
+	router.Get("/fast", WCacheRoutingByRequestURI(memoryStore, time.Minute, func(c *routing.Context) error {
+		return c.WriteData("fast")
+	}, WithStats(stats, "", "memory")))
+	mockRoutingRequest(router, "/fast")
+	mockRoutingRequest(router, "/fast")
+	mockRoutingRequest(router, "/fast?id=1")
+
+	snapshot := stats.Snapshot()
+	assert.Equal(t, StatsCounters{Hits: 1, Misses: 2, StoredBytes: 8}, snapshot.Routes["/nodes/:name"])
+	assert.Equal(t, StatsCounters{Misses: 1, StoredBytes: 4}, snapshot.Routes["apps"])
+	assert.Equal(t, StatsCounters{Misses: 1, StoredBytes: 5}, snapshot.Routes["/other"])
+	// the routes without templates are labeled as other, so the label values are bounded
+	assert.Equal(t, StatsCounters{Hits: 1, Misses: 4, StoredBytes: 8}, snapshot.Routes[statsOtherRoute])
+	assert.Len(t, snapshot.Routes, 4)
+	bounded := snapshot.Stores["bounded"]
+	assert.Equal(t, int64(1), bounded.Hits)
+	assert.Equal(t, int64(2), bounded.Misses)
+	assert.Equal(t, boundedStore.Stats().Bytes, bounded.Size)
+	assert.Equal(t, StatsCounters{Hits: 1, Misses: 4, StoredBytes: 17}, snapshot.Stores["memory"])
+	// the unnamed stores are not counted
+	assert.Len(t, snapshot.Stores, 2)
+
+	w := httptest.NewRecorder()
+	stats.PrometheusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), "# TYPE cache_route_hits_total counter\n")
+	assert.Contains(t, w.Body.String(), "cache_route_hits_total{route=\"/nodes/:name\"} 1\n")
+	assert.Contains(t, w.Body.String(), "cache_store_misses_total{store=\"memory\"} 4\n")
+	assert.Contains(t, w.Body.String(), "cache_store_evictions_total{store=\"bounded\"} 0\n")
+
+	w = httptest.NewRecorder()
+	stats.JSONHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var decoded StatsSnapshot
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+	assert.Equal(t, snapshot.Routes, decoded.Routes)
+
+	// a nil stats collects nothing
+	var none *Stats
+	none.add("route", "memory", memoryStore, statsHit, 1)
+}
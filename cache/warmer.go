@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// warmingKey marks the request replayed by the warmer, the cache handlers skip reading the cache and refresh it.
+// It is a context value of the gin request, and a user value of the fasthttp request.
+type warmingKey struct{}
+
+const warmingUserValue = "cache-warming"
+
+// WarmRequest the request template which the warmer replays to refresh the cache
+type WarmRequest struct {
+	// Method GET if empty
+	Method string
+	URL    string
+	// Header the headers of the request, e.g. the ones listed in Vary
+	Header http.Header
+}
+
+// Warmer refreshes the cache of the registered requests on a schedule, by replaying them to the handler
+// which the cache middleware or wrapper is installed on. So the handler and the store are the same as the requests of clients.
+type Warmer struct {
+	serve    func(r *WarmRequest) (int, error)
+	interval time.Duration
+	jitter   time.Duration
+	logger   Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+}
+
+// NewWarmer creates a warmer for the http handler, e.g. a gin engine.
+// Each request is refreshed every interval minus a random jitter, so the interval should be shorter than the cache duration
+// minus the jitter to refresh before expiry. The first refresh is delayed by a random jitter to spread the requests,
+// and the requests are only warmed once if interval is not greater than zero.
+func NewWarmer(handler http.Handler, interval, jitter time.Duration, logger Logger) *Warmer {
+	return newWarmer(func(r *WarmRequest) (int, error) {
+		req, err := http.NewRequestWithContext(context.WithValue(context.Background(), warmingKey{}, true), r.Method, r.URL, nil)
+		if err != nil {
+			return 0, err
+		}
+		// as a server request, the cache key may be the request uri
+		req.RequestURI = req.URL.RequestURI()
+		for k, v := range r.Header {
+			req.Header[k] = v
+		}
+		// the response is only checked for the status
+		w := newDetachedWriter()
+		handler.ServeHTTP(w, req)
+		return w.Status(), nil
+	}, interval, jitter, logger)
+}
+
+// NewRoutingWarmer creates a warmer for the fasthttp handler, e.g. the HandleRequest of a fasthttp-routing router, see NewWarmer.
+func NewRoutingWarmer(handler fasthttp.RequestHandler, interval, jitter time.Duration, logger Logger) *Warmer {
+	return newWarmer(func(r *WarmRequest) (int, error) {
+		var req fasthttp.Request
+		req.Header.SetMethod(r.Method)
+		req.SetRequestURI(r.URL)
+		for k, vs := range r.Header {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&req, nil, nil)
+		ctx.SetUserValue(warmingUserValue, true)
+		handler(ctx)
+		return ctx.Response.StatusCode(), nil
+	}, interval, jitter, logger)
+}
+
+func newWarmer(serve func(r *WarmRequest) (int, error), interval, jitter time.Duration, logger Logger) *Warmer {
+	if logger == nil {
+		logger = Discard{}
+	}
+	if interval > 0 && jitter >= interval {
+		jitter = interval / 2
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Warmer{
+		serve:    serve,
+		interval: interval,
+		jitter:   jitter,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Add registers the urls to refresh with GET requests
+func (w *Warmer) Add(urls ...string) {
+	for _, url := range urls {
+		w.AddRequest(WarmRequest{URL: url})
+	}
+}
+
+// AddRequest registers the request templates to refresh, the requests added after Close are dropped
+func (w *Warmer) AddRequest(reqs ...WarmRequest) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, r := range reqs {
+		r := r
+		if r.Method == "" {
+			r.Method = http.MethodGet
+		}
+		if w.ctx.Err() != nil {
+			w.logger.Errorf("warm cache error: warmer is closed, url: %s", r.URL)
+			continue
+		}
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.warming(&r)
+		}()
+	}
+}
+
+// Close stops refreshing, and waits for the requests being replayed
+func (w *Warmer) Close() {
+	w.lock.Lock()
+	w.cancel()
+	w.lock.Unlock()
+	w.wg.Wait()
+}
+
+func (w *Warmer) warming(r *WarmRequest) {
+	timer := time.NewTimer(w.randJitter())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := w.warm(r); err != nil {
+				w.logger.Errorf("warm cache error: %s, url: %s", err, r.URL)
+			}
+			if w.interval <= 0 {
+				return
+			}
+			timer.Reset(w.interval - w.randJitter())
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Warmer) warm(r *WarmRequest) error {
+	code, err := w.serve(r)
+	if err != nil {
+		return err
+	}
+	if !isSuccess(code) {
+		return fmt.Errorf("unexpected status code %d", code)
+	}
+	return nil
+}
+
+func (w *Warmer) randJitter() time.Duration {
+	if w.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(w.jitter)))
+}
+
+func isWarming(ctx context.Context) bool {
+	return ctx.Value(warmingKey{}) != nil
+}
+
+// IsReplayed returns whether the fasthttp request is replayed by the warmer, or by the stale-while-revalidate refresh
+// of the fasthttp-routing handlers, then the middlewares may skip their side effects, e.g. access logs and metrics
+func IsReplayed(ctx *fasthttp.RequestCtx) bool {
+	return ctx.UserValue(warmingUserValue) != nil
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl-go/v2/cache/persist"
+)
+
+func TestWarmer(t *testing.T) {
+	var calls, miss int32
+	memoryStore := persist.NewInMemoryStore(time.Minute)
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.Use(MCacheByRequestURI(memoryStore, 300*time.Millisecond, WithOnMissCache(func(c *gin.Context) {
+		atomic.AddInt32(&miss, 1)
+	})))
+	engine.GET("/nodes", func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(http.StatusOK, "nodes")
+	})
+
+	warmer := NewWarmer(engine, 200*time.Millisecond, 50*time.Millisecond, nil)
+	defer warmer.Close()
+	warmer.Add("/nodes")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the clients never pay the cost of the backend
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+		assert.Equal(t, "nodes", w.Body.String())
+		time.Sleep(60 * time.Millisecond)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&miss))
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&calls), int32(3))
+
+	warmer.Close()
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&calls))
+}
+
+func TestRoutingWarmer(t *testing.T) {
+	var calls int32
+	memoryStore := persist.NewInMemoryStore(time.Minute)
+	router := routing.New()
+	router.Get("/nodes", WCacheRoutingByRequestURI(memoryStore, time.Minute, func(c *routing.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return c.WriteData("nodes")
+	}))
+
+	// warm once
+	warmer := NewRoutingWarmer(router.HandleRequest, 0, 0, nil)
+	defer warmer.Close()
+	warmer.AddRequest(WarmRequest{URL: "/nodes"})
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "nodes", string(mockRoutingRequest(router, "/nodes").Response.Body()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestWarmerAddAfterDone(t *testing.T) {
+	var calls int32
+	warmer := newWarmer(func(r *WarmRequest) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return http.StatusOK, nil
+	}, 0, 0, nil)
+	defer warmer.Close()
+
+	// the requests are still warmed after the previous ones finish
+	warmer.Add("/a")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	warmer.Add("/b")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the requests added after close are dropped
+	warmer.Close()
+	warmer.Add("/c")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
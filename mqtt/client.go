@@ -43,6 +43,23 @@ func (c *Client) SetReconnectCallback(callback ReconnectCallback) {
 	c.callback = callback
 }
 
+// AddSubscriptions adds the subscriptions sent on every connection, it must be called before Start
+func (c *Client) AddSubscriptions(subs ...Subscription) {
+	for _, sub := range subs {
+		exists := false
+		for i, s := range c.ops.Subscriptions {
+			if s.Topic == sub.Topic {
+				c.ops.Subscriptions[i].QOS = sub.QOS
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			c.ops.Subscriptions = append(c.ops.Subscriptions, sub)
+		}
+	}
+}
+
 func (c *Client) ResetClient(ops *ClientOptions) {
 	c.ops.ClientID = ops.ClientID
 	c.ops.Username = ops.Username
@@ -0,0 +1,337 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/mqtt"
+	"github.com/baetyl/baetyl-go/v2/utils"
+)
+
+// echoWindow the duration to recognize the message forwarded by the bridge itself
+const echoWindow = time.Minute
+
+var (
+	ErrBridgeInvalidRule = errors.New("invalid bridge rule")
+)
+
+// BridgeDirection the direction of a bridge rule
+type BridgeDirection int
+
+const (
+	// BridgeOut forwards the messages of the local topic to the MQTT topic
+	BridgeOut BridgeDirection = iota
+	// BridgeIn forwards the messages of the MQTT topic to the local topic
+	BridgeIn
+	// BridgeBoth forwards the messages in both directions, the messages forwarded by the bridge are not forwarded back
+	BridgeBoth
+)
+
+// BridgeRule maps a local topic to an MQTT topic.
+// The MQTT topic of an inbound rule can be a filter, in which case the local topic is either a fixed topic
+// or a filter with the same wildcards, e.g. "cloud/+/events" is mapped to "local/+/events".
+type BridgeRule struct {
+	Local     string
+	Remote    string
+	Direction BridgeDirection
+	QOS       mqtt.QOS
+	// Codec encodes the local messages into the MQTT payloads, BytesCodec is used if nil
+	Codec Codec
+}
+
+func (r *BridgeRule) outbound() bool {
+	return r.Direction == BridgeOut || r.Direction == BridgeBoth
+}
+
+func (r *BridgeRule) inbound() bool {
+	return r.Direction == BridgeIn || r.Direction == BridgeBoth
+}
+
+// Bridge forwards the messages between the pubsub and the MQTT broker according to the rules.
+// The bridge is the observer of the client, the client is started and closed by the bridge.
+// The inbound messages are acknowledged after published to the pubsub, so they are redelivered by
+// the broker if the pubsub fails; the outbound messages are cached by the client during reconnecting.
+type Bridge struct {
+	pb      Pubsub
+	cli     *mqtt.Client
+	rules   []*BridgeRule
+	inbound *mqtt.Trie
+	subs    map[<-chan interface{}]*BridgeRule
+	echoes  *echoes
+	tomb    utils.Tomb
+	log     *log.Logger
+}
+
+// NewBridge creates a bridge between the pubsub and the client
+func NewBridge(pb Pubsub, cli *mqtt.Client, rules ...BridgeRule) (*Bridge, error) {
+	b := &Bridge{
+		pb:      pb,
+		cli:     cli,
+		inbound: mqtt.NewTrie(),
+		subs:    map[<-chan interface{}]*BridgeRule{},
+		echoes:  newEchoes(echoWindow),
+		log:     log.With(log.Any("pubsub", "bridge")),
+	}
+	for i := range rules {
+		r := rules[i]
+		if r.Codec == nil {
+			r.Codec = BytesCodec{}
+		}
+		if err := checkBridgeRule(&r); err != nil {
+			return nil, err
+		}
+		b.rules = append(b.rules, &r)
+		if r.inbound() {
+			b.inbound.Add(r.Remote, &r)
+		}
+	}
+	return b, nil
+}
+
+func checkBridgeRule(r *BridgeRule) error {
+	if !mqtt.CheckTopic(r.Remote, r.Direction == BridgeIn) || !mqtt.CheckTopic(r.Local, r.Direction == BridgeIn) {
+		return errors.Errorf("%s: local (%s) remote (%s)", ErrBridgeInvalidRule.Error(), r.Local, r.Remote)
+	}
+	// the wildcards of a local filter are replaced by the levels of the MQTT topic
+	if isFilter(r.Local) && wildcards(r.Local) != wildcards(r.Remote) {
+		return errors.Errorf("%s: the wildcards of local (%s) and remote (%s) mismatch", ErrBridgeInvalidRule.Error(), r.Local, r.Remote)
+	}
+	return nil
+}
+
+// Start subscribes the topics of the rules, then starts the client
+func (b *Bridge) Start() error {
+	var subs []mqtt.Subscription
+	for _, r := range b.rules {
+		if r.inbound() {
+			subs = append(subs, mqtt.Subscription{Topic: r.Remote, QOS: r.QOS})
+		}
+	}
+	b.cli.AddSubscriptions(subs...)
+
+	var fs []func() error
+	for _, r := range b.rules {
+		if !r.outbound() {
+			continue
+		}
+		ch, err := b.pb.Subscribe(r.Local, WithBlock(0))
+		if err != nil {
+			b.unsubscribe()
+			return err
+		}
+		b.subs[ch] = r
+		rule := r
+		fs = append(fs, func() error {
+			return b.forwarding(rule, ch)
+		})
+	}
+	if err := b.cli.Start(b); err != nil {
+		b.unsubscribe()
+		return err
+	}
+	if len(fs) > 0 {
+		return b.tomb.Go(fs...)
+	}
+	return nil
+}
+
+// Close stops forwarding and closes the client
+func (b *Bridge) Close() error {
+	b.tomb.Kill(nil)
+	err := b.cli.Close()
+	b.tomb.Wait()
+	b.unsubscribe()
+	return err
+}
+
+// OnPublish forwards the MQTT message to the local topics (see mqtt.Observer interface).
+// The error of the local publish is returned, then the message is not acknowledged and is redelivered by the broker.
+func (b *Bridge) OnPublish(pkt *packet.Publish) error {
+	topic := pkt.Message.Topic
+	payload := pkt.Message.Payload
+	if b.echoes.seen(remoteEcho(topic, payload)) {
+		// forwarded by the bridge itself
+		return nil
+	}
+	for _, v := range b.inbound.Match(topic) {
+		r := v.(*BridgeRule)
+		msg, err := r.Codec.Decode(payload)
+		if err != nil {
+			b.log.Warn("failed to decode message", log.Any("topic", topic), log.Error(err))
+			continue
+		}
+		local := r.Local
+		if isFilter(local) {
+			local = mapTopic(r.Remote, local, topic)
+		}
+		if r.outbound() {
+			// the message is encoded again when it comes back
+			if encoded, err := r.Codec.Encode(msg); err == nil {
+				b.echoes.add(localEcho(local, encoded))
+			}
+		}
+		if err = b.pb.Publish(local, msg); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// OnPuback (see mqtt.Observer interface)
+func (b *Bridge) OnPuback(*packet.Puback) error {
+	return nil
+}
+
+// OnError (see mqtt.Observer interface)
+func (b *Bridge) OnError(err error) {
+	b.log.Warn("bridge client is disconnected", log.Error(err))
+}
+
+func (b *Bridge) forwarding(r *BridgeRule, ch <-chan interface{}) error {
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			payload, err := r.Codec.Encode(msg)
+			if err != nil {
+				b.log.Warn("failed to encode message", log.Any("topic", r.Local), log.Error(err))
+				continue
+			}
+			if b.echoes.seen(localEcho(r.Local, payload)) {
+				// forwarded by the bridge itself
+				continue
+			}
+			if r.inbound() {
+				b.echoes.add(remoteEcho(r.Remote, payload))
+			}
+			// blocks during reconnecting if the cache of the client is full
+			if err = b.cli.Publish(r.QOS, r.Remote, payload, 0, false, false); err != nil {
+				return nil
+			}
+		case <-b.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+func (b *Bridge) unsubscribe() {
+	for ch, r := range b.subs {
+		b.pb.Unsubscribe(r.Local, ch)
+		delete(b.subs, ch)
+	}
+}
+
+// wildcards returns the wildcards of the filter in order
+func wildcards(filter string) string {
+	var res []string
+	for _, level := range strings.Split(filter, "/") {
+		if level == "+" || level == "#" {
+			res = append(res, level)
+		}
+	}
+	return strings.Join(res, "/")
+}
+
+// mapTopic replaces the wildcards of filter to by the levels of topic matched by filter from
+func mapTopic(from, to, topic string) string {
+	var captures []string
+	levels := strings.Split(topic, "/")
+	for i, level := range strings.Split(from, "/") {
+		if level == "+" {
+			captures = append(captures, levels[i])
+		} else if level == "#" {
+			captures = append(captures, strings.Join(levels[i:], "/"))
+			break
+		}
+	}
+	res := strings.Split(to, "/")
+	for i, level := range res {
+		if level == "+" || level == "#" {
+			res[i], captures = captures[0], captures[1:]
+		}
+	}
+	return strings.Join(res, "/")
+}
+
+func localEcho(topic string, payload []byte) uint64 {
+	return echoKey("local", topic, payload)
+}
+
+func remoteEcho(topic string, payload []byte) uint64 {
+	return echoKey("remote", topic, payload)
+}
+
+func echoKey(side, topic string, payload []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(side))
+	h.Write([]byte{0})
+	h.Write([]byte(topic))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return h.Sum64()
+}
+
+// echoes remembers the messages forwarded by the bridge for a while, which are skipped when they come back
+type echoes struct {
+	window  time.Duration
+	entries map[uint64]*echo
+	purged  time.Time
+	lock    sync.Mutex
+}
+
+type echo struct {
+	count  int
+	expire time.Time
+}
+
+func newEchoes(window time.Duration) *echoes {
+	return &echoes{
+		window:  window,
+		entries: map[uint64]*echo{},
+		purged:  time.Now(),
+	}
+}
+
+func (e *echoes) add(key uint64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	now := time.Now()
+	if now.Sub(e.purged) > e.window {
+		for k, v := range e.entries {
+			if now.After(v.expire) {
+				delete(e.entries, k)
+			}
+		}
+		e.purged = now
+	}
+	v, ok := e.entries[key]
+	if !ok {
+		v = &echo{}
+		e.entries[key] = v
+	}
+	v.count++
+	v.expire = now.Add(e.window)
+}
+
+// seen returns true and forgets the message once if it is forwarded by the bridge
+func (e *echoes) seen(key uint64) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	v, ok := e.entries[key]
+	if !ok {
+		return false
+	}
+	v.count--
+	if v.count == 0 {
+		delete(e.entries, key)
+	}
+	return time.Now().Before(v.expire)
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/broker"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/mqtt"
+)
+
+func newBridgeClient(port, cid string, subs ...mqtt.Subscription) *mqtt.Client {
+	ops := mqtt.NewClientOptions()
+	ops.Address = "tcp://localhost:" + port
+	ops.ClientID = cid
+	ops.CleanSession = true
+	ops.Timeout = time.Second
+	ops.Subscriptions = subs
+	return mqtt.NewClient(ops)
+}
+
+func TestBridge(t *testing.T) {
+	port, quit, done := broker.Run(broker.NewEngine(broker.NewMemoryBackend()), "tcp")
+	defer func() {
+		close(quit)
+		<-done
+	}()
+
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	_, err = NewBridge(pb, newBridgeClient(port, "invalid"), BridgeRule{Local: "local/#", Remote: "cloud/#", Direction: BridgeOut})
+	assert.Error(t, err)
+	_, err = NewBridge(pb, newBridgeClient(port, "invalid"), BridgeRule{Local: "local/+/events", Remote: "cloud/#", Direction: BridgeIn})
+	assert.Error(t, err)
+
+	b, err := NewBridge(pb, newBridgeClient(port, "bridge"),
+		BridgeRule{Local: "local/status", Remote: "cloud/status", Direction: BridgeOut, QOS: 1},
+		BridgeRule{Local: "local/+/events", Remote: "cloud/+/events", Direction: BridgeIn, QOS: 1},
+		BridgeRule{Local: "local/cmd", Remote: "cloud/cmd", Direction: BridgeBoth},
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, b.Start())
+	defer b.Close()
+
+	received := make(chan *packet.Message, 10)
+	peer := newBridgeClient(port, "peer", mqtt.Subscription{Topic: "cloud/#", QOS: 1})
+	assert.NoError(t, peer.Start(mqtt.NewObserverWrapper(func(pkt *packet.Publish) error {
+		received <- &pkt.Message
+		return nil
+	}, nil, nil)))
+	defer peer.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	events, err := pb.Subscribe("local/n1/events")
+	assert.NoError(t, err)
+	cmd, err := pb.Subscribe("local/cmd")
+	assert.NoError(t, err)
+
+	// local to MQTT
+	assert.NoError(t, pb.Publish("local/status", "online"))
+	msg := receiveMessage(t, received)
+	assert.Equal(t, "cloud/status", msg.Topic)
+	assert.Equal(t, "online", string(msg.Payload))
+
+	// MQTT to local
+	assert.NoError(t, peer.Publish(1, "cloud/n1/events", []byte("e1"), 0, false, false))
+	assert.Equal(t, []byte("e1"), receiveMsg(t, events))
+	assert.Equal(t, "cloud/n1/events", receiveMessage(t, received).Topic)
+
+	// bidirectional, the messages are not forwarded back
+	assert.NoError(t, pb.Publish("local/cmd", "c1"))
+	assert.Equal(t, "c1", receiveMsg(t, cmd))
+	msg = receiveMessage(t, received)
+	assert.Equal(t, "cloud/cmd", msg.Topic)
+	assert.Equal(t, "c1", string(msg.Payload))
+
+	assert.NoError(t, peer.Publish(0, "cloud/cmd", []byte("c2"), 0, false, false))
+	assert.Equal(t, []byte("c2"), receiveMsg(t, cmd))
+	assert.Equal(t, "c2", string(receiveMessage(t, received).Payload))
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Len(t, received, 0)
+	assert.Len(t, cmd, 0)
+}
+
+func TestBridgeLocalTimeout(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	b, err := NewBridge(pb, newBridgeClient("0", "bridge"), BridgeRule{Local: "local/events", Remote: "cloud/events", Direction: BridgeIn})
+	assert.NoError(t, err)
+	slow, err := pb.Subscribe("local/events", WithSize(0), WithBlock(time.Millisecond))
+	assert.NoError(t, err)
+
+	// the error is returned, so the message is not acknowledged and is redelivered by the broker
+	pkt := packet.NewPublish()
+	pkt.Message.Topic = "cloud/events"
+	pkt.Message.Payload = []byte("e1")
+	err = b.OnPublish(pkt)
+	assert.Error(t, err)
+	assert.Equal(t, ErrPubsubTimeout, errors.Cause(err))
+	assert.Equal(t, uint64(1), pb.Dropped("local/events", slow))
+}
+
+func TestMapTopic(t *testing.T) {
+	assert.Equal(t, "local/n1/events", mapTopic("cloud/+/events", "local/+/events", "cloud/n1/events"))
+	assert.Equal(t, "local/a/b/c", mapTopic("cloud/#", "local/#", "cloud/a/b/c"))
+	assert.Equal(t, "x/n1/y/a/b", mapTopic("cloud/+/#", "x/+/y/#", "cloud/n1/a/b"))
+	assert.Equal(t, "", wildcards("a/b"))
+	assert.Equal(t, "+/#", wildcards("+/a/#"))
+}
+
+func receiveMessage(t *testing.T, ch <-chan *packet.Message) *packet.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("message is not received")
+		return nil
+	}
+}
+
+func receiveMsg(t *testing.T, ch <-chan interface{}) interface{} {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("message is not received")
+		return nil
+	}
+}
@@ -0,0 +1,408 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/mqtt"
+)
+
+const offsetsFile = "offsets.json"
+
+// maxRetentionInterval the longest interval to enforce the retention age
+const maxRetentionInterval = time.Minute
+
+var (
+	ErrUnsupportedMessage = errors.New("unsupported message type")
+)
+
+// Codec encodes the messages of the durable pubsub into the log
+type Codec interface {
+	Encode(msg interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// BytesCodec the default codec, which accepts []byte and string messages and decodes them into []byte
+type BytesCodec struct{}
+
+// Encode (see Codec interface)
+func (BytesCodec) Encode(msg interface{}) ([]byte, error) {
+	switch m := msg.(type) {
+	case []byte:
+		return m, nil
+	case string:
+		return []byte(m), nil
+	}
+	return nil, errors.Trace(ErrUnsupportedMessage)
+}
+
+// Decode (see Codec interface)
+func (BytesCodec) Decode(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+// JSONCodec encodes the messages in json, and decodes them into the type of Type
+type JSONCodec struct {
+	Type reflect.Type
+}
+
+// NewJSONCodec creates a json codec decoding the messages into the type of sample, e.g. &Event{}
+func NewJSONCodec(sample interface{}) *JSONCodec {
+	return &JSONCodec{Type: reflect.TypeOf(sample)}
+}
+
+// Encode (see Codec interface)
+func (c *JSONCodec) Encode(msg interface{}) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// Decode (see Codec interface)
+func (c *JSONCodec) Decode(data []byte) (interface{}, error) {
+	typ := c.Type
+	isPtr := typ.Kind() == reflect.Ptr
+	if isPtr {
+		typ = typ.Elem()
+	}
+	v := reflect.New(typ)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if isPtr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+// DurableConfig the config of durable pubsub
+type DurableConfig struct {
+	// Dir the directory of the log segments and the consumer group offsets
+	Dir string `yaml:"dir" json:"dir" validate:"nonzero"`
+	// Size the channel size of subscriptions
+	Size int `yaml:"size" json:"size" default:"100"`
+	// SegmentBytes a new segment is started when the active one reaches the size
+	SegmentBytes int64 `yaml:"segmentBytes" json:"segmentBytes" default:"16777216"`
+	// RetentionBytes the oldest segments are removed when the log exceeds the size, zero means unlimited
+	RetentionBytes int64 `yaml:"retentionBytes" json:"retentionBytes"`
+	// RetentionAge the segments not written for the duration are removed, zero means unlimited.
+	// It is checked every tenth of the duration, at least every minute, and the active segment is always kept.
+	RetentionAge time.Duration `yaml:"retentionAge" json:"retentionAge"`
+	// Sync flushes every message to disk before Publish returns
+	Sync bool `yaml:"sync" json:"sync"`
+}
+
+// Record the message delivered by the durable subscriptions, the offset can be committed for a consumer group
+type Record struct {
+	Offset uint64
+	Topic  string
+	Time   time.Time
+	Msg    interface{}
+}
+
+// DurablePubsub is a Pubsub backed by a segmented append-only log on disk.
+// Subscribe receives the new messages as they are published, while SubscribeFrom and SubscribeGroup
+// replay the log from an offset and deliver *Record, so no message is dropped across restarts.
+type DurablePubsub struct {
+	*pubsub
+	cfg     DurableConfig
+	codec   Codec
+	log     *segmentedLog
+	cursors map[<-chan interface{}]*cursor
+	// offsets the committed offsets by consumer group and by topic
+	offsets map[string]map[string]uint64
+	lock    sync.Mutex
+	// closing stops the cursors, cursors may come and go so a tomb does not fit
+	closing chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+	logger  *log.Logger
+}
+
+// cursor delivers the records of the log matching the topic to the subscription
+type cursor struct {
+	topic  string
+	filter *mqtt.Trie
+	sub    *subscription
+	reader *logReader
+	done   chan struct{}
+	dead   chan struct{}
+}
+
+// NewDurablePubsub opens the log in the directory, the messages are encoded by codec, BytesCodec is used if nil
+func NewDurablePubsub(cfg DurableConfig, codec Codec) (*DurablePubsub, error) {
+	if codec == nil {
+		codec = BytesCodec{}
+	}
+	l, err := openLog(cfg.Dir, cfg.SegmentBytes, cfg.RetentionBytes, cfg.RetentionAge, cfg.Sync)
+	if err != nil {
+		return nil, err
+	}
+	d := &DurablePubsub{
+		pubsub:  newPubsub(cfg.Size),
+		cfg:     cfg,
+		codec:   codec,
+		log:     l,
+		cursors: map[<-chan interface{}]*cursor{},
+		offsets: map[string]map[string]uint64{},
+		closing: make(chan struct{}),
+		logger:  log.With(log.Any("pubsub", "durable")),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(cfg.Dir, offsetsFile))
+	if err == nil {
+		err = json.Unmarshal(data, &d.offsets)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		l.close()
+		return nil, errors.Trace(err)
+	}
+	if cfg.RetentionAge > 0 {
+		d.wg.Add(1)
+		go d.retaining()
+	}
+	return d, nil
+}
+
+// Publish appends the message to the log, then sends it to the subscribers
+func (d *DurablePubsub) Publish(topic string, msg interface{}) error {
+	payload, err := d.codec.Encode(msg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = d.log.append(topic, payload); err != nil {
+		return err
+	}
+	return d.pubsub.Publish(topic, msg)
+}
+
+// SubscribeFrom replays the messages of the topic from the offset, then keeps delivering the new messages.
+// The offset of the oldest retained message is used if the offset has been removed by retention.
+// The subscription delivers *Record, and never drops messages, so the options of backpressure policies are ignored.
+func (d *DurablePubsub) SubscribeFrom(topic string, offset uint64, opts ...SubscribeOption) (<-chan interface{}, error) {
+	if isFilter(topic) && !mqtt.CheckTopic(topic, true) {
+		return nil, errors.Trace(ErrPubsubInvalidTopic)
+	}
+	filter := mqtt.NewTrie()
+	filter.Add(topic, true)
+
+	sub := newSubscription(topic, d.cfg.Size, opts...)
+	// the cursor blocks until the record is received
+	sub.policy = PolicyBlock
+	sub.timeout = 0
+	c := &cursor{
+		topic:  topic,
+		filter: filter,
+		sub:    sub,
+		reader: newLogReader(d.log, offset),
+		done:   make(chan struct{}),
+		dead:   make(chan struct{}),
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return nil, errors.Trace(ErrPubsubClosed)
+	}
+	d.cursors[c.sub.ch] = c
+	d.wg.Add(1)
+	go d.reading(c)
+	return c.sub.ch, nil
+}
+
+// SubscribeGroup subscribes the topic from the offset committed by the consumer group for the topic,
+// a new group starts from the next message. See SubscribeFrom.
+func (d *DurablePubsub) SubscribeGroup(topic, group string, opts ...SubscribeOption) (<-chan interface{}, error) {
+	offset, ok := d.Committed(group, topic)
+	if !ok {
+		_, offset = d.log.offsets()
+	}
+	return d.SubscribeFrom(topic, offset, opts...)
+}
+
+// Commit saves the offset of the next message to consume for the consumer group subscribing the topic,
+// i.e. Record.Offset+1. The offsets of a group are kept by topic, since the topics subscribed by a group
+// skip the messages of each other.
+func (d *DurablePubsub) Commit(group, topic string, offset uint64) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.offsets[group] == nil {
+		d.offsets[group] = map[string]uint64{}
+	}
+	d.offsets[group][topic] = offset
+	data, err := json.Marshal(d.offsets)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	path := filepath.Join(d.cfg.Dir, offsetsFile)
+	if err = ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(path+".tmp", path))
+}
+
+// Committed returns the offset committed by the consumer group for the topic
+func (d *DurablePubsub) Committed(group, topic string) (uint64, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	offset, ok := d.offsets[group][topic]
+	return offset, ok
+}
+
+// Offsets returns the offset of the oldest retained message and the offset of the next message
+func (d *DurablePubsub) Offsets() (uint64, uint64) {
+	return d.log.offsets()
+}
+
+// Unsubscribe removes the subscription created by Subscribe, SubscribeFrom or SubscribeGroup
+func (d *DurablePubsub) Unsubscribe(topic string, ch <-chan interface{}) error {
+	d.lock.Lock()
+	c, ok := d.cursors[ch]
+	delete(d.cursors, ch)
+	d.lock.Unlock()
+	if !ok {
+		return d.pubsub.Unsubscribe(topic, ch)
+	}
+	close(c.done)
+	<-c.dead
+	c.sub.close()
+	return nil
+}
+
+// Dropped (see Pubsub interface), the subscriptions replaying the log never drop messages
+func (d *DurablePubsub) Dropped(topic string, ch <-chan interface{}) uint64 {
+	d.lock.Lock()
+	_, ok := d.cursors[ch]
+	d.lock.Unlock()
+	if ok {
+		return 0
+	}
+	return d.pubsub.Dropped(topic, ch)
+}
+
+// Topics (see Pubsub interface), including the topics of the subscriptions replaying the log
+func (d *DurablePubsub) Topics() []string {
+	var res []string
+	for _, ts := range d.Stats() {
+		res = append(res, ts.Topic)
+	}
+	return res
+}
+
+// Stats (see Pubsub interface), including the subscriptions replaying the log
+func (d *DurablePubsub) Stats() []TopicStats {
+	res := d.pubsub.Stats()
+	index := map[string]int{}
+	for i, ts := range res {
+		index[ts.Topic] = i
+	}
+	d.lock.Lock()
+	for _, c := range d.cursors {
+		i, ok := index[c.topic]
+		if !ok {
+			i = len(res)
+			index[c.topic] = i
+			res = append(res, TopicStats{Topic: c.topic})
+		}
+		res[i].Subscribers = append(res[i].Subscribers, c.sub.stats())
+	}
+	d.lock.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Topic < res[j].Topic
+	})
+	return res
+}
+
+// Close stops the subscriptions, closes their channels and the log
+func (d *DurablePubsub) Close() error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.closing)
+	d.lock.Unlock()
+
+	d.wg.Wait()
+	d.lock.Lock()
+	for ch, c := range d.cursors {
+		delete(d.cursors, ch)
+		c.sub.close()
+	}
+	d.lock.Unlock()
+	err := d.log.close()
+	d.pubsub.Close()
+	return err
+}
+
+// retaining removes the expired segments periodically, since the log may not be written for a long time
+func (d *DurablePubsub) retaining() {
+	defer d.wg.Done()
+	interval := d.cfg.RetentionAge / 10
+	if interval > maxRetentionInterval {
+		interval = maxRetentionInterval
+	}
+	if interval <= 0 {
+		interval = d.cfg.RetentionAge
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.log.retain()
+		case <-d.closing:
+			return
+		}
+	}
+}
+
+func (d *DurablePubsub) reading(c *cursor) {
+	defer d.wg.Done()
+	defer close(c.dead)
+	defer c.reader.close()
+	for {
+		// get the notification before reading, so no append is missed
+		notify := d.log.wait()
+		rec, err := c.reader.next()
+		if err == errLogEnd {
+			select {
+			case <-notify:
+				continue
+			case <-c.done:
+				return
+			case <-d.closing:
+				return
+			}
+		}
+		if err != nil {
+			// the subscription is closed, so the subscriber sees the end instead of waiting forever
+			d.logger.Error("failed to read log, the subscription is closed", log.Any("topic", c.topic), log.Error(err))
+			d.lock.Lock()
+			delete(d.cursors, c.sub.ch)
+			d.lock.Unlock()
+			c.sub.close()
+			return
+		}
+		if len(c.filter.Match(rec.topic)) == 0 {
+			continue
+		}
+		msg, err := d.codec.Decode(rec.payload)
+		if err != nil {
+			d.logger.Warn("failed to decode message", log.Any("topic", rec.topic), log.Any("offset", rec.offset), log.Error(err))
+			continue
+		}
+		select {
+		case c.sub.ch <- &Record{Offset: rec.offset, Topic: rec.topic, Time: rec.time, Msg: msg}:
+		case <-c.done:
+			return
+		case <-d.closing:
+			return
+		}
+	}
+}
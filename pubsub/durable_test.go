@@ -0,0 +1,204 @@
+package pubsub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type event struct {
+	Device string `json:"device"`
+	Value  int    `json:"value"`
+}
+
+func receive(t *testing.T, ch <-chan interface{}) *Record {
+	select {
+	case msg := <-ch:
+		return msg.(*Record)
+	case <-time.After(time.Second):
+		t.Fatal("no record received")
+	}
+	return nil
+}
+
+func TestDurablePubsub(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir(), Size: 10, SegmentBytes: 100}
+	pb, err := NewDurablePubsub(cfg, NewJSONCodec(&event{}))
+	require.NoError(t, err)
+
+	live, err := pb.Subscribe("devices/d1/events")
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, pb.Publish("devices/d1/events", &event{Device: "d1", Value: i}))
+		require.NoError(t, pb.Publish("devices/d2/events", &event{Device: "d2", Value: i}))
+	}
+	assert.Len(t, drain(live), 5)
+	oldest, next := pb.Offsets()
+	assert.Equal(t, uint64(0), oldest)
+	assert.Equal(t, uint64(10), next)
+
+	// consume a part, then restart
+	ch, err := pb.SubscribeFrom("devices/+/events", 0)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		rec := receive(t, ch)
+		assert.Equal(t, uint64(i), rec.Offset)
+		assert.Equal(t, &event{Device: []string{"d1", "d2"}[i%2], Value: i / 2}, rec.Msg)
+	}
+	require.NoError(t, pb.Commit("g1", "devices/+/events", 4))
+	require.NoError(t, pb.Close())
+
+	pb, err = NewDurablePubsub(cfg, NewJSONCodec(&event{}))
+	require.NoError(t, err)
+	defer pb.Close()
+	_, next = pb.Offsets()
+	assert.Equal(t, uint64(10), next)
+
+	ch, err = pb.SubscribeGroup("devices/+/events", "g1")
+	require.NoError(t, err)
+	rec := receive(t, ch)
+	assert.Equal(t, uint64(4), rec.Offset)
+	assert.Equal(t, "devices/d1/events", rec.Topic)
+	assert.NoError(t, pb.Unsubscribe("devices/+/events", ch))
+	drain(ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// the offsets of a group are kept by topic
+	offset, ok := pb.Committed("g1", "devices/+/events")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4), offset)
+	_, ok = pb.Committed("g1", "devices/d2/events")
+	assert.False(t, ok)
+
+	// replay a single topic, then receive new messages
+	ch, err = pb.SubscribeFrom("devices/d2/events", 0)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, uint64(2*i+1), receive(t, ch).Offset)
+	}
+	require.NoError(t, pb.Publish("devices/d2/events", &event{Device: "d2", Value: 5}))
+	rec = receive(t, ch)
+	assert.Equal(t, uint64(10), rec.Offset)
+	assert.Equal(t, &event{Device: "d2", Value: 5}, rec.Msg)
+
+	// a new group starts from the next message
+	ch, err = pb.SubscribeGroup("devices/#", "g2")
+	require.NoError(t, err)
+	require.NoError(t, pb.Publish("devices/d3/events", &event{Device: "d3"}))
+	assert.Equal(t, uint64(11), receive(t, ch).Offset)
+
+	// the subscriptions replaying the log are included in the statistics
+	assert.Equal(t, []string{"devices/#", "devices/d2/events"}, pb.Topics())
+	stats := pb.Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, TopicStats{Topic: "devices/#", Subscribers: []SubscriberStats{{Policy: PolicyBlock, Size: 10}}}, stats[0])
+	assert.Equal(t, uint64(0), pb.Dropped("devices/#", ch))
+}
+
+func TestDurablePubsubRetention(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir(), SegmentBytes: 50, RetentionBytes: 150}
+	pb, err := NewDurablePubsub(cfg, nil)
+	require.NoError(t, err)
+	defer pb.Close()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, pb.Publish("t", "0123456789"))
+	}
+	oldest, next := pb.Offsets()
+	assert.Equal(t, uint64(20), next)
+	assert.True(t, oldest > 0)
+	segments, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.log"))
+	assert.LessOrEqual(t, len(segments), 4)
+
+	// the removed messages are skipped
+	ch, err := pb.SubscribeFrom("t", 0, WithSize(100))
+	require.NoError(t, err)
+	rec := receive(t, ch)
+	assert.Equal(t, oldest, rec.Offset)
+	assert.Equal(t, []byte("0123456789"), rec.Msg)
+}
+
+func TestDurablePubsubRetentionAge(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir(), SegmentBytes: 50, RetentionAge: 200 * time.Millisecond}
+	pb, err := NewDurablePubsub(cfg, nil)
+	require.NoError(t, err)
+	defer pb.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pb.Publish("t", "0123456789"))
+	}
+	oldest, _ := pb.Offsets()
+	assert.Equal(t, uint64(0), oldest)
+
+	// the expired segments are removed without new messages, the active one is kept
+	assert.Eventually(t, func() bool {
+		segments, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.log"))
+		return len(segments) == 1
+	}, 2*time.Second, 20*time.Millisecond)
+	oldest, next := pb.Offsets()
+	assert.True(t, oldest > 0)
+	assert.Equal(t, uint64(10), next)
+}
+
+func TestDurablePubsubRecover(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir()}
+	pb, err := NewDurablePubsub(cfg, nil)
+	require.NoError(t, err)
+	require.NoError(t, pb.Publish("t", "m0"))
+	require.NoError(t, pb.Publish("t", "m1"))
+	require.NoError(t, pb.Close())
+
+	// a broken record at the tail is truncated
+	segments, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.log"))
+	require.Len(t, segments, 1)
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	f.Write([]byte{0, 0, 0, 100, 1, 2})
+	f.Close()
+
+	pb, err = NewDurablePubsub(cfg, nil)
+	require.NoError(t, err)
+	defer pb.Close()
+	require.NoError(t, pb.Publish("t", "m2"))
+	ch, err := pb.SubscribeFrom("t", 0)
+	require.NoError(t, err)
+	for i, m := range []string{"m0", "m1", "m2"} {
+		rec := receive(t, ch)
+		assert.Equal(t, uint64(i), rec.Offset)
+		assert.Equal(t, []byte(m), rec.Msg)
+	}
+
+	assert.Error(t, pb.Publish("t", 1))
+}
+
+func TestDurablePubsubReadError(t *testing.T) {
+	cfg := DurableConfig{Dir: t.TempDir()}
+	pb, err := NewDurablePubsub(cfg, nil)
+	require.NoError(t, err)
+	defer pb.Close()
+	require.NoError(t, pb.Publish("t", "m0"))
+
+	// the segment can not be opened
+	segments, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.log"))
+	require.Len(t, segments, 1)
+	require.NoError(t, os.Remove(segments[0]))
+	require.NoError(t, os.Symlink(segments[0], segments[0]))
+
+	// the subscription is closed and removed
+	ch, err := pb.SubscribeFrom("t", 0)
+	require.NoError(t, err)
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("the subscription is not closed")
+	}
+	assert.Empty(t, pb.Stats())
+	assert.Empty(t, pb.Topics())
+	assert.NoError(t, pb.Unsubscribe("t", ch))
+}
@@ -4,3 +4,9 @@ type Handler interface {
 	OnMessage(interface{}) error
 	OnTimeout() error
 }
+
+// TypedHandler handles the messages of a typed channel, see NewTypedProcessor
+type TypedHandler[T any] interface {
+	OnMessage(T) error
+	OnTimeout() error
+}
@@ -0,0 +1,395 @@
+package pubsub
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+const (
+	segmentExt = ".log"
+	// recordHeaderSize the length and the crc of a record
+	recordHeaderSize = 8
+	// recordMetaSize the offset, the timestamp and the topic length of a record
+	recordMetaSize = 18
+)
+
+var (
+	errLogEnd    = errors.New("end of log")
+	errLogClosed = errors.New("log is closed")
+)
+
+// record the entry of the log
+type record struct {
+	offset  uint64
+	time    time.Time
+	topic   string
+	payload []byte
+}
+
+type segment struct {
+	base    uint64
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// segmentedLog is an append-only log split into segment files named by the offset of their first record.
+// A record is encoded as: length(4) crc32(4) offset(8) timestamp(8) topicLength(2) topic payload
+type segmentedLog struct {
+	dir            string
+	segmentBytes   int64
+	retentionBytes int64
+	retentionAge   time.Duration
+	sync           bool
+
+	segments []*segment
+	active   *os.File
+	next     uint64
+	closed   bool
+	// notify is closed and replaced when records are appended
+	notify chan struct{}
+	lock   sync.RWMutex
+}
+
+func openLog(dir string, segmentBytes, retentionBytes int64, retentionAge time.Duration, sync bool) (*segmentedLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	l := &segmentedLog{
+		dir:            dir,
+		segmentBytes:   segmentBytes,
+		retentionBytes: retentionBytes,
+		retentionAge:   retentionAge,
+		sync:           sync,
+		notify:         make(chan struct{}),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		l.segments = append(l.segments, &segment{
+			base:    base,
+			path:    filepath.Join(dir, e.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(l.segments, func(i, j int) bool {
+		return l.segments[i].base < l.segments[j].base
+	})
+
+	if len(l.segments) == 0 {
+		if err := l.roll(); err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+
+	// recover the last segment, the records after the first broken one are truncated
+	last := l.segments[len(l.segments)-1]
+	next, size, err := recoverSegment(last.path, last.base)
+	if err != nil {
+		return nil, err
+	}
+	if size != last.size {
+		if err := os.Truncate(last.path, size); err != nil {
+			return nil, errors.Trace(err)
+		}
+		last.size = size
+	}
+	l.next = next
+	l.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	l.enforceRetention()
+	return l, nil
+}
+
+// recoverSegment scans the segment, returns the next offset and the size of valid records
+func recoverSegment(path string, base uint64) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	defer f.Close()
+	next := base
+	var pos int64
+	for {
+		rec, n, err := readRecord(f, pos)
+		if err != nil {
+			return next, pos, nil
+		}
+		next = rec.offset + 1
+		pos += n
+	}
+}
+
+// readRecord reads the record at pos, returns io.ErrUnexpectedEOF if the record is incomplete
+func readRecord(f *os.File, pos int64) (*record, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := f.ReadAt(header[:], pos); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length < recordMetaSize {
+		return nil, 0, errors.New("invalid record length")
+	}
+	body := make([]byte, length)
+	if _, err := f.ReadAt(body, pos+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("invalid record checksum")
+	}
+	topicLen := int(binary.BigEndian.Uint16(body[16:18]))
+	if recordMetaSize+topicLen > len(body) {
+		return nil, 0, errors.New("invalid record topic length")
+	}
+	return &record{
+		offset:  binary.BigEndian.Uint64(body[0:8]),
+		time:    time.Unix(0, int64(binary.BigEndian.Uint64(body[8:16]))),
+		topic:   string(body[recordMetaSize : recordMetaSize+topicLen]),
+		payload: body[recordMetaSize+topicLen:],
+	}, recordHeaderSize + int64(length), nil
+}
+
+func encodeRecord(offset uint64, t time.Time, topic string, payload []byte) []byte {
+	length := recordMetaSize + len(topic) + len(payload)
+	b := make([]byte, recordHeaderSize+length)
+	binary.BigEndian.PutUint32(b[0:4], uint32(length))
+	binary.BigEndian.PutUint64(b[8:16], offset)
+	binary.BigEndian.PutUint64(b[16:24], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint16(b[24:26], uint16(len(topic)))
+	copy(b[26:], topic)
+	copy(b[26+len(topic):], payload)
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b[recordHeaderSize:]))
+	return b
+}
+
+// append writes a record, returns its offset
+func (l *segmentedLog) append(topic string, payload []byte) (uint64, error) {
+	if len(topic) > 0xFFFF {
+		return 0, errors.New("topic is too long")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return 0, errors.Trace(errLogClosed)
+	}
+
+	last := l.segments[len(l.segments)-1]
+	if l.segmentBytes > 0 && last.size >= l.segmentBytes {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+		l.enforceRetention()
+		last = l.segments[len(l.segments)-1]
+	}
+
+	offset := l.next
+	b := encodeRecord(offset, time.Now(), topic, payload)
+	if _, err := l.active.Write(b); err != nil {
+		return 0, errors.Trace(err)
+	}
+	if l.sync {
+		if err := l.active.Sync(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	last.size += int64(len(b))
+	last.modTime = time.Now()
+	l.next++
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return offset, nil
+}
+
+// roll starts a new segment with the next offset, the lock must be held
+func (l *segmentedLog) roll() error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.next, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if l.active != nil {
+		l.active.Close()
+	}
+	l.active = f
+	l.segments = append(l.segments, &segment{base: l.next, path: path, modTime: time.Now()})
+	return nil
+}
+
+// enforceRetention removes the oldest segments exceeding the retention limits, the active segment is always kept
+func (l *segmentedLog) enforceRetention() {
+	var total int64
+	for _, s := range l.segments {
+		total += s.size
+	}
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		expired := l.retentionAge > 0 && time.Since(oldest.modTime) > l.retentionAge
+		oversize := l.retentionBytes > 0 && total > l.retentionBytes
+		if !expired && !oversize {
+			return
+		}
+		os.Remove(oldest.path)
+		total -= oldest.size
+		l.segments = l.segments[1:]
+	}
+}
+
+// retain enforces the retention limits, so the segments expire without new records
+func (l *segmentedLog) retain() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.closed {
+		l.enforceRetention()
+	}
+}
+
+// offsets returns the offset of the oldest retained record and the next offset
+func (l *segmentedLog) offsets() (uint64, uint64) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.segments[0].base, l.next
+}
+
+// wait returns a channel closed when records are appended after the call
+func (l *segmentedLog) wait() <-chan struct{} {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.notify
+}
+
+// locate returns the segment containing the offset, the oldest segment if the offset is not retained,
+// and whether it is the last segment
+func (l *segmentedLog) locate(offset uint64) (*segment, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].base > offset
+	})
+	if i > 0 {
+		i--
+	}
+	return l.segments[i], i == len(l.segments)-1
+}
+
+func (l *segmentedLog) close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.notify)
+	return l.active.Close()
+}
+
+// logReader reads the records sequentially from an offset
+type logReader struct {
+	log    *segmentedLog
+	offset uint64
+	seg    *segment
+	file   *os.File
+	pos    int64
+}
+
+func newLogReader(l *segmentedLog, offset uint64) *logReader {
+	return &logReader{log: l, offset: offset}
+}
+
+// next returns the next record, errLogEnd is returned if there is no more record for now
+func (r *logReader) next() (*record, error) {
+	for {
+		if r.file == nil {
+			oldest, _ := r.log.offsets()
+			if r.offset < oldest {
+				r.offset = oldest
+			}
+			seg, _ := r.log.locate(r.offset)
+			f, err := os.Open(seg.path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// removed by retention
+					continue
+				}
+				return nil, errors.Trace(err)
+			}
+			r.seg, r.file, r.pos = seg, f, 0
+		}
+
+		rec, n, err := readRecord(r.file, r.pos)
+		if err != nil {
+			_, last := r.log.locate(r.seg.base)
+			if last {
+				// the record is being written
+				return nil, errLogEnd
+			}
+			// move to the next segment
+			r.close()
+			if next, _ := r.log.locate(r.offset); next == r.seg {
+				r.offset = r.nextBase()
+			}
+			continue
+		}
+		r.pos += n
+		if rec.offset < r.offset {
+			continue
+		}
+		r.offset = rec.offset + 1
+		return rec, nil
+	}
+}
+
+// nextBase returns the base offset of the segment after the current one
+func (r *logReader) nextBase() uint64 {
+	r.log.lock.RLock()
+	defer r.log.lock.RUnlock()
+	for _, s := range r.log.segments {
+		if s.base > r.seg.base {
+			return s.base
+		}
+	}
+	return r.log.next
+}
+
+func (r *logReader) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
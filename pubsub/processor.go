@@ -1,8 +1,11 @@
 package pubsub
 
 import (
+	"hash/fnv"
 	"time"
 
+	"github.com/jpillora/backoff"
+
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
 	"github.com/baetyl/baetyl-go/v2/utils"
@@ -17,47 +20,136 @@ type Processor interface {
 	Close()
 }
 
-type processor struct {
-	channel <-chan interface{}
+// DeadLetter the message published to the dead-letter topic when the handler keeps failing,
+// it can be encoded by JSONCodec into a durable topic
+type DeadLetter struct {
+	Msg interface{} `json:"msg"`
+	// Err the error message of the last attempt
+	Err      string `json:"err"`
+	Attempts int    `json:"attempts"`
+}
+
+// ProcessorOption the option of a processor
+type ProcessorOption func(o *processorOptions)
+
+// WithConcurrency handles the messages by n workers, the messages are handled one by one by default
+func WithConcurrency(n int) ProcessorOption {
+	return func(o *processorOptions) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// WithRetry retries the message at most retries times if the handler fails,
+// the interval starts from min and doubles for each retry until max
+func WithRetry(retries int, min, max time.Duration) ProcessorOption {
+	return func(o *processorOptions) {
+		o.retries = retries
+		o.backoff = backoff.Backoff{Min: min, Max: max, Factor: 2}
+	}
+}
+
+// WithDeadLetter publishes a *DeadLetter to the topic when the message still fails after all retries
+func WithDeadLetter(pb Pubsub, topic string) ProcessorOption {
+	return func(o *processorOptions) {
+		o.deadLetter = pb
+		o.deadLetterTopic = topic
+	}
+}
+
+// WithOrderingKey handles the messages with the same key in order by the same worker,
+// the messages are handled in any order by default if the concurrency is greater than one
+func WithOrderingKey(key func(msg interface{}) string) ProcessorOption {
+	return func(o *processorOptions) {
+		o.key = key
+	}
+}
+
+type processorOptions struct {
+	concurrency     int
+	retries         int
+	backoff         backoff.Backoff
+	deadLetter      Pubsub
+	deadLetterTopic string
+	key             func(msg interface{}) string
+}
+
+type processor[T any] struct {
+	processorOptions
+	channel <-chan T
 	timeout time.Duration
-	handler Handler
+	handler TypedHandler[T]
 	tomb    utils.Tomb
 	log     *log.Logger
+	// queues the messages dispatched to the workers, there is a queue for each worker if the ordering key is set
+	queues []chan T
+}
+
+// NewProcessor creates a processor handling the messages of the channel, the handler is notified by OnTimeout
+// if no message is received within the timeout, then the processor stops. There is no timeout if timeout is zero.
+func NewProcessor(ch <-chan interface{}, timeout time.Duration, handler Handler, opts ...ProcessorOption) Processor {
+	return newProcessor[interface{}](ch, timeout, handler, opts...)
+}
+
+// NewTypedProcessor creates a processor handling the messages of the typed channel, see NewProcessor
+func NewTypedProcessor[T any](ch <-chan T, timeout time.Duration, handler TypedHandler[T], opts ...ProcessorOption) Processor {
+	return newProcessor(ch, timeout, handler, opts...)
 }
 
-func NewProcessor(ch <-chan interface{}, timeout time.Duration, handler Handler) Processor {
-	return &processor{
-		channel: ch,
-		timeout: timeout,
-		handler: handler,
-		tomb:    utils.Tomb{},
-		log:     log.L().With(log.Any("pubsub", "processor")),
+func newProcessor[T any](ch <-chan T, timeout time.Duration, handler TypedHandler[T], opts ...ProcessorOption) *processor[T] {
+	p := &processor[T]{
+		processorOptions: processorOptions{concurrency: 1},
+		channel:          ch,
+		timeout:          timeout,
+		handler:          handler,
+		tomb:             utils.Tomb{},
+		log:              log.L().With(log.Any("pubsub", "processor")),
 	}
+	for _, opt := range opts {
+		opt(&p.processorOptions)
+	}
+	queue := make(chan T)
+	for i := 0; i < p.concurrency; i++ {
+		if p.key != nil && i > 0 {
+			queue = make(chan T)
+		}
+		p.queues = append(p.queues, queue)
+	}
+	return p
 }
 
-func (p *processor) Start() {
+func (p *processor[T]) Start() {
+	fs := []func() error{p.processing}
 	if p.timeout > 0 {
-		p.tomb.Go(p.timerProcessing)
-	} else {
-		p.tomb.Go(p.processing)
+		fs[0] = p.timerProcessing
+	}
+	for i := range p.queues {
+		queue := p.queues[i]
+		fs = append(fs, func() error {
+			return p.working(queue)
+		})
 	}
+	p.tomb.Go(fs...)
 }
 
-func (p *processor) Close() {
+func (p *processor[T]) Close() {
 	p.tomb.Kill(nil)
 	p.tomb.Wait()
 }
 
-func (p *processor) timerProcessing() error {
+func (p *processor[T]) timerProcessing() error {
 	timer := time.NewTimer(p.timeout)
 	defer timer.Stop()
 	for {
 		select {
-		case msg := <-p.channel:
-			if p.handler != nil {
-				if err := p.handler.OnMessage(msg); err != nil {
-					p.log.Error("failed to handle message", log.Error(err))
-				}
+		case msg, ok := <-p.channel:
+			if !ok {
+				// the subscription is removed
+				return nil
+			}
+			if !p.dispatch(msg) {
+				return nil
 			}
 			timer.Reset(p.timeout)
 		case <-timer.C:
@@ -74,17 +166,83 @@ func (p *processor) timerProcessing() error {
 	}
 }
 
-func (p *processor) processing() error {
+func (p *processor[T]) processing() error {
 	for {
 		select {
-		case msg := <-p.channel:
-			if p.handler != nil {
-				if err := p.handler.OnMessage(msg); err != nil {
-					p.log.Error("failed to handle message", log.Error(err))
-				}
+		case msg, ok := <-p.channel:
+			if !ok {
+				// the subscription is removed
+				return nil
 			}
+			if !p.dispatch(msg) {
+				return nil
+			}
+		case <-p.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+// dispatch sends the message to a worker, returns false if the processor is closed
+func (p *processor[T]) dispatch(msg T) bool {
+	queue := p.queues[0]
+	if p.key != nil && len(p.queues) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(p.key(msg)))
+		queue = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+	select {
+	case queue <- msg:
+		return true
+	case <-p.tomb.Dying():
+		return false
+	}
+}
+
+func (p *processor[T]) working(queue <-chan T) error {
+	for {
+		select {
+		case msg := <-queue:
+			p.handle(msg)
 		case <-p.tomb.Dying():
 			return nil
 		}
 	}
 }
+
+// handle calls the handler with retries, the message is sent to the dead-letter topic if all attempts fail
+func (p *processor[T]) handle(msg T) {
+	if p.handler == nil {
+		return
+	}
+	bf := p.backoff
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		if err = p.handler.OnMessage(msg); err == nil {
+			return
+		}
+		if attempts > p.retries {
+			break
+		}
+		p.log.Warn("failed to handle message, retry later", log.Any("attempts", attempts), log.Error(err))
+		timer := time.NewTimer(bf.Duration())
+		select {
+		case <-timer.C:
+		case <-p.tomb.Dying():
+			timer.Stop()
+			p.log.Error("failed to handle message before the processor is closed", log.Any("attempts", attempts), log.Error(err))
+			return
+		}
+	}
+
+	p.log.Error("failed to handle message", log.Any("attempts", attempts), log.Error(err))
+	if p.deadLetter == nil {
+		return
+	}
+	dl := &DeadLetter{Msg: msg, Err: err.Error(), Attempts: attempts}
+	if err = p.deadLetter.Publish(p.deadLetterTopic, dl); err != nil {
+		p.log.Error("failed to publish message to dead-letter topic", log.Any("topic", p.deadLetterTopic), log.Error(err))
+	}
+}
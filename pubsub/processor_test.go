@@ -1,11 +1,14 @@
 package pubsub
 
 import (
+	"encoding/json"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
 )
 
 const (
@@ -95,3 +98,160 @@ func (h *hdUp) OnMessage(msg interface{}) error {
 func (h *hdUp) OnTimeout() error {
 	return nil
 }
+
+type hdFunc func(msg interface{}) error
+
+func (h hdFunc) OnMessage(msg interface{}) error {
+	return h(msg)
+}
+
+func (h hdFunc) OnTimeout() error {
+	return nil
+}
+
+func TestProcessorRetry(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ch, err := pb.Subscribe(topicDown)
+	assert.NoError(t, err)
+	dead, err := pb.Subscribe("test.dead")
+	assert.NoError(t, err)
+
+	var lock sync.Mutex
+	attempts := map[string]int{}
+	p := NewProcessor(ch, 0, hdFunc(func(msg interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+		m := msg.(string)
+		attempts[m]++
+		if m == "bad" || attempts[m] < 2 {
+			return errors.New("failed")
+		}
+		return nil
+	}), WithRetry(2, time.Millisecond, 5*time.Millisecond), WithDeadLetter(pb, "test.dead"))
+	p.Start()
+	defer p.Close()
+
+	assert.NoError(t, pb.Publish(topicDown, "good"))
+	assert.NoError(t, pb.Publish(topicDown, "bad"))
+
+	select {
+	case msg := <-dead:
+		dl, ok := msg.(*DeadLetter)
+		assert.True(t, ok)
+		assert.Equal(t, "bad", dl.Msg)
+		assert.Equal(t, 3, dl.Attempts)
+		assert.Equal(t, "failed", dl.Err)
+		data, err := json.Marshal(dl)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"msg":"bad","err":"failed","attempts":3}`, string(data))
+	case <-time.After(time.Second):
+		t.Fatal("dead letter is not received")
+	}
+	lock.Lock()
+	assert.Equal(t, map[string]int{"good": 2, "bad": 3}, attempts)
+	lock.Unlock()
+}
+
+func TestProcessorConcurrency(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ch, err := pb.Subscribe(topicDown)
+	assert.NoError(t, err)
+
+	// the messages are blocked until all workers are busy
+	var wg sync.WaitGroup
+	wg.Add(4)
+	release := make(chan struct{})
+	p := NewProcessor(ch, 0, hdFunc(func(msg interface{}) error {
+		wg.Done()
+		<-release
+		return nil
+	}), WithConcurrency(4))
+	p.Start()
+	defer p.Close()
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, pb.Publish(topicDown, i))
+	}
+	wg.Wait()
+	close(release)
+}
+
+func TestProcessorOrderingKey(t *testing.T) {
+	pb, err := NewPubsub(100)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ch, err := pb.Subscribe(topicDown)
+	assert.NoError(t, err)
+
+	type message struct {
+		key string
+		seq int
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(60)
+	received := map[string][]int{}
+	p := NewProcessor(ch, 0, hdFunc(func(msg interface{}) error {
+		defer wg.Done()
+		m := msg.(message)
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		received[m.key] = append(received[m.key], m.seq)
+		lock.Unlock()
+		return nil
+	}), WithConcurrency(3), WithOrderingKey(func(msg interface{}) string {
+		return msg.(message).key
+	}))
+	p.Start()
+	defer p.Close()
+
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			assert.NoError(t, pb.Publish(topicDown, message{key: key, seq: i}))
+		}
+	}
+	wg.Wait()
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Len(t, received[key], 20)
+		assert.IsIncreasing(t, received[key])
+	}
+}
+
+func TestProcessorTimeoutWithConcurrency(t *testing.T) {
+	pb, err := NewPubsub(1)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ch, err := pb.Subscribe(topicDown)
+	assert.NoError(t, err)
+	timeout := make(chan struct{})
+	p := NewProcessor(ch, 50*time.Millisecond, &hdTimeout{timeout: timeout}, WithConcurrency(2))
+	p.Start()
+	defer p.Close()
+
+	select {
+	case <-timeout:
+	case <-time.After(time.Second):
+		t.Fatal("timeout is not handled")
+	}
+}
+
+type hdTimeout struct {
+	timeout chan struct{}
+}
+
+func (h *hdTimeout) OnMessage(msg interface{}) error {
+	return nil
+}
+
+func (h *hdTimeout) OnTimeout() error {
+	close(h.timeout)
+	return nil
+}
@@ -1,13 +1,16 @@
 package pubsub
 
 import (
+	"context"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 	"github.com/baetyl/baetyl-go/v2/log"
+	"github.com/baetyl/baetyl-go/v2/mqtt"
 )
 
 const (
@@ -15,102 +18,264 @@ const (
 )
 
 var (
-	ErrPubsubTimeout = errors.New("failed to send message to topic because of timeout")
+	ErrPubsubTimeout      = errors.New("failed to send message to topic because of timeout")
+	ErrPubsubInvalidTopic = errors.New("invalid topic filter")
+	ErrPubsubClosed       = errors.New("pubsub is closed")
 )
 
+// Pubsub publishes messages to the subscribers of topics.
+// The topic of Subscribe can be a filter with MQTT wildcards, '+' matches a single level and '#' matches multiple levels.
 type Pubsub interface {
 	Publish(topic string, msg interface{}) error
-	Subscribe(topic string) (<-chan interface{}, error)
+	// Subscribe subscribes the topic, the options decide the channel size and what happens when the channel is full
+	Subscribe(topic string, opts ...SubscribeOption) (<-chan interface{}, error)
+	// SubscribeContext subscribes the topic, the subscription is removed when the ctx is done
+	SubscribeContext(ctx context.Context, topic string, opts ...SubscribeOption) (<-chan interface{}, error)
+	// Unsubscribe removes the subscription and closes its channel
 	Unsubscribe(topic string, ch <-chan interface{}) error
+	// Dropped returns the number of messages dropped by the subscription
+	Dropped(topic string, ch <-chan interface{}) uint64
+	// Topics returns the topics and filters having subscribers
+	Topics() []string
+	// Stats returns the statistics of the subscriptions of all topics and filters
+	Stats() []TopicStats
+	// Close removes all subscriptions and closes their channels
 	io.Closer
 }
 
+// TopicStats the statistics of the subscriptions of a topic or a filter
+type TopicStats struct {
+	Topic       string            `json:"topic"`
+	Subscribers []SubscriberStats `json:"subscribers"`
+}
+
+// SubscriberStats the statistics of a subscription
+type SubscriberStats struct {
+	Policy Policy `json:"policy"`
+	// Size the channel size
+	Size int `json:"size"`
+	// Queued the number of messages in the channel waiting to be received
+	Queued  int    `json:"queued"`
+	Dropped uint64 `json:"dropped"`
+}
+
+type subscriptions map[string]map[<-chan interface{}]*subscription
+
 type pubsub struct {
 	size     int
-	channels map[string]map[<-chan interface{}]chan interface{}
+	channels subscriptions
+	// filters the subscriptions of wildcard filters, which are also added to the trie for matching
+	filters  subscriptions
+	trie     *mqtt.Trie
 	chanLock sync.RWMutex
+	closed   bool
 	log      *log.Logger
 }
 
 func NewPubsub(size int) (Pubsub, error) {
+	return newPubsub(size), nil
+}
+
+func newPubsub(size int) *pubsub {
 	return &pubsub{
 		size:     size,
-		channels: make(map[string]map[<-chan interface{}]chan interface{}),
+		channels: make(subscriptions),
+		filters:  make(subscriptions),
+		trie:     mqtt.NewTrie(),
 		log:      log.With(log.Any("pubsub", "memory")),
-	}, nil
+	}
 }
 
+// Publish sends the message to all matched subscriptions, ErrPubsubTimeout is returned
+// if any subscription with PolicyBlock does not receive the message in time.
 func (m *pubsub) Publish(topic string, msg interface{}) error {
-	var errs []string
-	if chs := m.getChannel(topic); chs != nil {
-		for _, ch := range chs {
-			err := m.publish(ch, msg)
-			if err != nil {
-				errs = append(errs, err.Error())
-			}
+	var err error
+	for _, sub := range m.getChannel(topic) {
+		ok, e := sub.send(msg)
+		if e != nil {
+			m.log.Warn("publish message timeout", log.Any("topic", topic))
+			err = e
+		}
+		if !ok {
+			m.log.Warn("disconnect slow subscriber", log.Any("topic", sub.topic))
+			m.remove(sub)
+			sub.close()
 		}
 	}
-	if len(errs) > 0 {
-		return errors.New(strings.Join(errs, "\n"))
+	return err
+}
+
+func (m *pubsub) Subscribe(topic string, opts ...SubscribeOption) (<-chan interface{}, error) {
+	sub, err := m.subscribe(topic, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return sub.ch, nil
 }
 
-func (m *pubsub) Subscribe(topic string) (<-chan interface{}, error) {
+func (m *pubsub) SubscribeContext(ctx context.Context, topic string, opts ...SubscribeOption) (<-chan interface{}, error) {
+	sub, err := m.subscribe(topic, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.Unsubscribe(topic, sub.ch)
+		case <-sub.done:
+		}
+	}()
+	return sub.ch, nil
+}
+
+func (m *pubsub) subscribe(topic string, opts ...SubscribeOption) (*subscription, error) {
+	wildcard := isFilter(topic)
+	if wildcard && !mqtt.CheckTopic(topic, true) {
+		return nil, errors.Trace(ErrPubsubInvalidTopic)
+	}
+
 	m.chanLock.Lock()
 	defer m.chanLock.Unlock()
+	if m.closed {
+		return nil, errors.Trace(ErrPubsubClosed)
+	}
 
-	chs, ok := m.channels[topic]
+	subs := m.channels
+	if wildcard {
+		subs = m.filters
+	}
+	chs, ok := subs[topic]
 	if !ok {
-		chs = map[<-chan interface{}]chan interface{}{}
-		m.channels[topic] = chs
+		chs = map[<-chan interface{}]*subscription{}
+		subs[topic] = chs
+	}
+	sub := newSubscription(topic, m.size, opts...)
+	chs[sub.ch] = sub
+	if wildcard {
+		m.trie.Add(topic, sub)
 	}
-	ch := make(chan interface{}, m.size)
-	chs[ch] = ch
-	return ch, nil
+	return sub, nil
 }
 
 func (m *pubsub) Unsubscribe(topic string, ch <-chan interface{}) error {
 	m.chanLock.Lock()
 	defer m.chanLock.Unlock()
-	if chs, ok := m.channels[topic]; ok {
-		if _, exist := chs[ch]; exist {
-			delete(chs, ch)
-		}
+	if sub := m.lookup(topic, ch); sub != nil {
+		m.delete(sub)
+		sub.close()
 	}
 	return nil
 }
 
+func (m *pubsub) Dropped(topic string, ch <-chan interface{}) uint64 {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	if sub := m.lookup(topic, ch); sub != nil {
+		return sub.droppedCount()
+	}
+	return 0
+}
+
+func (m *pubsub) Topics() []string {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	var res []string
+	for _, subs := range []subscriptions{m.channels, m.filters} {
+		for topic := range subs {
+			res = append(res, topic)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (m *pubsub) Stats() []TopicStats {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	var res []TopicStats
+	for _, subs := range []subscriptions{m.channels, m.filters} {
+		for topic, chs := range subs {
+			ts := TopicStats{Topic: topic}
+			for _, sub := range chs {
+				ts.Subscribers = append(ts.Subscribers, sub.stats())
+			}
+			res = append(res, ts)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Topic < res[j].Topic
+	})
+	return res
+}
+
 func (m *pubsub) Close() error {
 	m.chanLock.Lock()
 	defer m.chanLock.Unlock()
-	for topic, chs := range m.channels {
-		for k := range chs {
-			delete(chs, k)
+	m.closed = true
+	for _, subs := range []subscriptions{m.channels, m.filters} {
+		for topic, chs := range subs {
+			for k, sub := range chs {
+				delete(chs, k)
+				sub.close()
+			}
+			delete(subs, topic)
 		}
-		delete(m.channels, topic)
 	}
+	m.trie.Reset()
 	return nil
 }
 
-func (m *pubsub) publish(ch chan interface{}, msg interface{}) error {
-	timer := time.NewTimer(pubTimeout)
-	defer timer.Stop()
+// getChannel returns the subscriptions of the topic exactly or by filters
+func (m *pubsub) getChannel(topic string) []*subscription {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	var res []*subscription
+	for _, sub := range m.channels[topic] {
+		res = append(res, sub)
+	}
+	// the exact match is kept fast if there is no filter
+	if len(m.filters) > 0 {
+		for _, v := range m.trie.Match(topic) {
+			res = append(res, v.(*subscription))
+		}
+	}
+	return res
+}
 
-	select {
-	case ch <- msg:
-	case <-timer.C:
-		m.log.Warn("publish message timeout")
-		return ErrPubsubTimeout
+func (m *pubsub) lookup(topic string, ch <-chan interface{}) *subscription {
+	subs := m.channels
+	if isFilter(topic) {
+		subs = m.filters
 	}
-	return nil
+	return subs[topic][ch]
 }
 
-func (m *pubsub) getChannel(topic string) map[<-chan interface{}]chan interface{} {
-	m.chanLock.RLock()
-	defer m.chanLock.RUnlock()
-	if chs, ok := m.channels[topic]; ok {
-		return chs
+func (m *pubsub) remove(sub *subscription) {
+	m.chanLock.Lock()
+	defer m.chanLock.Unlock()
+	m.delete(sub)
+}
+
+// delete removes the subscription, the lock must be held
+func (m *pubsub) delete(sub *subscription) {
+	wildcard := isFilter(sub.topic)
+	subs := m.channels
+	if wildcard {
+		subs = m.filters
 	}
-	return nil
+	chs, ok := subs[sub.topic]
+	if !ok || chs[sub.ch] != sub {
+		return
+	}
+	delete(chs, sub.ch)
+	if len(chs) == 0 {
+		delete(subs, sub.topic)
+	}
+	if wildcard {
+		m.trie.Remove(sub.topic, sub)
+	}
+}
+
+func isFilter(topic string) bool {
+	return strings.ContainsAny(topic, "+#")
 }
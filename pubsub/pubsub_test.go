@@ -1,10 +1,14 @@
 package pubsub
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
 )
 
 const (
@@ -59,3 +63,188 @@ func Reading(t *testing.T, ch <-chan interface{}) {
 		}
 	}
 }
+
+func TestPubsubWildcard(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	exact, err := pb.Subscribe("devices/d1/events")
+	assert.NoError(t, err)
+	single, err := pb.Subscribe("devices/+/events")
+	assert.NoError(t, err)
+	multi, err := pb.Subscribe("devices/#")
+	assert.NoError(t, err)
+	_, err = pb.Subscribe("devices/#/events")
+	assert.Error(t, err)
+
+	assert.NoError(t, pb.Publish("devices/d1/events", "e1"))
+	assert.NoError(t, pb.Publish("devices/d2/events", "e2"))
+	assert.NoError(t, pb.Publish("devices/d2/status", "s2"))
+	assert.NoError(t, pb.Publish("nodes/n1", "n1"))
+
+	assert.Equal(t, []interface{}{"e1"}, drain(exact))
+	assert.Equal(t, []interface{}{"e1", "e2"}, drain(single))
+	assert.Equal(t, []interface{}{"e1", "e2", "s2"}, drain(multi))
+
+	assert.NoError(t, pb.Unsubscribe("devices/+/events", single))
+	assert.NoError(t, pb.Publish("devices/d3/events", "e3"))
+	assert.Empty(t, drain(single))
+	assert.Equal(t, []interface{}{"e3"}, drain(multi))
+}
+
+func drain(ch <-chan interface{}) []interface{} {
+	var msgs []interface{}
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestPubsubBackpressure(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	block, err := pb.Subscribe("t", WithSize(1), WithBlock(time.Millisecond))
+	assert.NoError(t, err)
+	newest, err := pb.Subscribe("t", WithSize(2), WithDropNewest())
+	assert.NoError(t, err)
+	oldest, err := pb.Subscribe("t", WithSize(2), WithDropOldest())
+	assert.NoError(t, err)
+	slow, err := pb.Subscribe("t", WithSize(2), WithDisconnect())
+	assert.NoError(t, err)
+
+	assert.NoError(t, pb.Publish("t", 1))
+	assert.Equal(t, ErrPubsubTimeout, pb.Publish("t", 2))
+	assert.Equal(t, ErrPubsubTimeout, pb.Publish("t", 3))
+
+	assert.Equal(t, []interface{}{1}, drain(block))
+	assert.Equal(t, uint64(2), pb.Dropped("t", block))
+	assert.Equal(t, []interface{}{1, 2}, drain(newest))
+	assert.Equal(t, uint64(1), pb.Dropped("t", newest))
+	assert.Equal(t, []interface{}{2, 3}, drain(oldest))
+	assert.Equal(t, uint64(1), pb.Dropped("t", oldest))
+
+	// the slow subscriber is disconnected
+	assert.Equal(t, []interface{}{1, 2}, drain(slow))
+	_, ok := <-slow
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), pb.Dropped("t", slow))
+
+	// block until delivered
+	blocked, err := pb.Subscribe("b", WithSize(0), WithBlock(0))
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-blocked
+	}()
+	assert.NoError(t, pb.Publish("b", 1))
+
+	// an unbuffered subscription drops the new message instead of spinning
+	unbuffered, err := pb.Subscribe("u", WithSize(0), WithDropOldest())
+	assert.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- pb.Publish("u", 1)
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("publish to an unbuffered subscription dropping the oldest is blocked")
+	}
+	assert.Empty(t, drain(unbuffered))
+	assert.Equal(t, uint64(1), pb.Dropped("u", unbuffered))
+}
+
+func TestPubsubStats(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	assert.Empty(t, pb.Topics())
+	ch1, err := pb.Subscribe("b", WithSize(2), WithDropNewest())
+	assert.NoError(t, err)
+	_, err = pb.Subscribe("b")
+	assert.NoError(t, err)
+	_, err = pb.Subscribe("a/+")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/+", "b"}, pb.Topics())
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, pb.Publish("b", i))
+	}
+	stats := pb.Stats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, TopicStats{Topic: "a/+", Subscribers: []SubscriberStats{{Policy: PolicyBlock, Size: 10}}}, stats[0])
+	assert.Equal(t, "b", stats[1].Topic)
+	assert.ElementsMatch(t, []SubscriberStats{
+		{Policy: PolicyDropNewest, Size: 2, Queued: 2, Dropped: 1},
+		{Policy: PolicyBlock, Size: 10, Queued: 3},
+	}, stats[1].Subscribers)
+
+	assert.NoError(t, pb.Unsubscribe("b", ch1))
+	assert.Equal(t, []interface{}{0, 1}, drain(ch1))
+	_, ok := <-ch1
+	assert.False(t, ok)
+	assert.Len(t, pb.Stats()[1].Subscribers, 1)
+}
+
+func TestPubsubClose(t *testing.T) {
+	pb, err := NewPubsub(1)
+	assert.NoError(t, err)
+
+	ch, err := pb.Subscribe("t")
+	assert.NoError(t, err)
+	filter, err := pb.Subscribe("#")
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		// consumers are not blocked forever
+		for range ch {
+		}
+		for range filter {
+		}
+		close(done)
+	}()
+	assert.NoError(t, pb.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("channels are not closed")
+	}
+
+	_, err = pb.Subscribe("t")
+	assert.Equal(t, ErrPubsubClosed, errors.Cause(err))
+	assert.NoError(t, pb.Publish("t", 1))
+	assert.Empty(t, pb.Topics())
+}
+
+func TestPubsubSubscribeContext(t *testing.T) {
+	pb, err := NewPubsub(1)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := pb.SubscribeContext(ctx, "t")
+	assert.NoError(t, err)
+	assert.NoError(t, pb.Publish("t", 1))
+	assert.Equal(t, 1, <-ch)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Empty(t, pb.Topics())
+
+	_, err = pb.SubscribeContext(context.Background(), "a/#/b")
+	assert.Equal(t, ErrPubsubInvalidTopic, errors.Cause(err))
+}
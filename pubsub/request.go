@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+// ReplyTopicPrefix the prefix of the temporary reply topics
+const ReplyTopicPrefix = "$reply/"
+
+// RequestMessage the message published by Request and received by the responders
+type RequestMessage struct {
+	// ID the correlation id of the request
+	ID string
+	// ReplyTo the temporary topic to which the reply is published
+	ReplyTo string
+	Msg     interface{}
+}
+
+// ReplyMessage the message published by the responders to the reply topic of the request
+type ReplyMessage struct {
+	ID  string
+	Msg interface{}
+	Err error
+}
+
+// Request publishes the message to the topic and waits for the reply until the ctx is done.
+// The reply is received from a temporary topic, which is removed when Request returns.
+func Request(ctx context.Context, pb Pubsub, topic string, msg interface{}) (interface{}, error) {
+	id := uuid.New().String()
+	replyTo := ReplyTopicPrefix + id
+	ch, err := pb.SubscribeContext(ctx, replyTo, WithSize(1))
+	if err != nil {
+		return nil, err
+	}
+	defer pb.Unsubscribe(replyTo, ch)
+
+	if err = pb.Publish(topic, &RequestMessage{ID: id, ReplyTo: replyTo, Msg: msg}); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				if err = ctx.Err(); err != nil {
+					return nil, errors.Trace(err)
+				}
+				return nil, errors.Trace(ErrPubsubClosed)
+			}
+			reply, ok := v.(*ReplyMessage)
+			if !ok || reply.ID != id {
+				continue
+			}
+			return reply.Msg, reply.Err
+		case <-ctx.Done():
+			return nil, errors.Trace(ctx.Err())
+		}
+	}
+}
+
+// ResponderFunc handles the message of a request, the result or the error is replied to the requester
+type ResponderFunc func(msg interface{}) (interface{}, error)
+
+// Responder replies the requests of a topic, the requests are handled by a processor,
+// so the processor options such as WithConcurrency are supported
+type Responder struct {
+	pb        Pubsub
+	topic     string
+	ch        <-chan interface{}
+	handler   ResponderFunc
+	processor Processor
+	log       *log.Logger
+}
+
+// NewResponder subscribes the topic to reply the requests
+func NewResponder(pb Pubsub, topic string, handler ResponderFunc, opts ...ProcessorOption) (*Responder, error) {
+	ch, err := pb.Subscribe(topic)
+	if err != nil {
+		return nil, err
+	}
+	r := &Responder{
+		pb:      pb,
+		topic:   topic,
+		ch:      ch,
+		handler: handler,
+		log:     log.With(log.Any("pubsub", "responder"), log.Any("topic", topic)),
+	}
+	r.processor = NewProcessor(ch, 0, r, opts...)
+	return r, nil
+}
+
+func (r *Responder) Start() {
+	r.processor.Start()
+}
+
+func (r *Responder) Close() {
+	r.pb.Unsubscribe(r.topic, r.ch)
+	r.processor.Close()
+}
+
+// OnMessage replies the request (see Handler interface)
+func (r *Responder) OnMessage(msg interface{}) error {
+	req, ok := msg.(*RequestMessage)
+	if !ok {
+		r.log.Warn("ignore message which is not a request")
+		return nil
+	}
+	res, err := r.handler(req.Msg)
+	return r.pb.Publish(req.ReplyTo, &ReplyMessage{ID: req.ID, Msg: res, Err: err})
+}
+
+// OnTimeout (see Handler interface)
+func (r *Responder) OnTimeout() error {
+	return nil
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+func TestRequest(t *testing.T) {
+	pb, err := NewPubsub(10)
+	require.NoError(t, err)
+	defer pb.Close()
+
+	r, err := NewResponder(pb, "devices/get", func(msg interface{}) (interface{}, error) {
+		name := msg.(string)
+		switch name {
+		case "bad":
+			return nil, errors.New("device not found")
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+		return strings.ToUpper(name), nil
+	}, WithConcurrency(4))
+	require.NoError(t, err)
+	r.Start()
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := Request(ctx, pb, "devices/get", "d1")
+	assert.NoError(t, err)
+	assert.Equal(t, "D1", reply)
+
+	_, err = Request(ctx, pb, "devices/get", "bad")
+	assert.EqualError(t, err, "device not found")
+
+	// timeout
+	short, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	_, err = Request(short, pb, "devices/get", "slow")
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	// no responder
+	short, cancel3 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel3()
+	_, err = Request(short, pb, "devices/set", "d1")
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	// concurrent requests are correlated
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		name := string(rune('a' + i))
+		go func() {
+			reply, err := Request(ctx, pb, "devices/get", name)
+			if err == nil && reply != strings.ToUpper(name) {
+				err = errors.Errorf("unexpected reply %v of %s", reply, name)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	// the temporary reply topics are removed
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, []string{"devices/get"}, pb.Topics())
+}
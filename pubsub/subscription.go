@@ -0,0 +1,175 @@
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy decides what happens when a message is published to a full subscription
+type Policy int
+
+const (
+	// PolicyBlock blocks the publisher until the message is delivered or the timeout is reached, then drops the message
+	PolicyBlock Policy = iota
+	// PolicyDropNewest drops the message being published
+	PolicyDropNewest
+	// PolicyDropOldest drops the oldest message in the channel to make room, the channel works as a ring buffer.
+	// An unbuffered channel holds no message to drop, so the message being published is dropped instead.
+	PolicyDropOldest
+	// PolicyDisconnect unsubscribes the slow subscriber and closes its channel
+	PolicyDisconnect
+)
+
+// SubscribeOption the option of a subscription
+type SubscribeOption func(s *subscription)
+
+// WithSize sets the channel size of the subscription, the size of pubsub is used by default
+func WithSize(size int) SubscribeOption {
+	return func(s *subscription) {
+		if size >= 0 {
+			s.size = size
+		}
+	}
+}
+
+// WithBlock blocks the publisher for at most timeout when the subscription is full, it is the default policy with a 10ms timeout.
+// The publisher is blocked until the message is delivered if timeout is not greater than zero.
+func WithBlock(timeout time.Duration) SubscribeOption {
+	return func(s *subscription) {
+		s.policy = PolicyBlock
+		s.timeout = timeout
+	}
+}
+
+// WithDropNewest drops the new messages when the subscription is full
+func WithDropNewest() SubscribeOption {
+	return func(s *subscription) {
+		s.policy = PolicyDropNewest
+	}
+}
+
+// WithDropOldest drops the oldest messages when the subscription is full
+func WithDropOldest() SubscribeOption {
+	return func(s *subscription) {
+		s.policy = PolicyDropOldest
+	}
+}
+
+// WithDisconnect disconnects the subscriber when the subscription is full
+func WithDisconnect() SubscribeOption {
+	return func(s *subscription) {
+		s.policy = PolicyDisconnect
+	}
+}
+
+type subscription struct {
+	topic   string
+	size    int
+	policy  Policy
+	timeout time.Duration
+	ch      chan interface{}
+	dropped uint64
+
+	// done wakes up the blocked publishers before the channel is closed
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+	lock   sync.RWMutex
+}
+
+func newSubscription(topic string, size int, opts ...SubscribeOption) *subscription {
+	s := &subscription{
+		topic:   topic,
+		size:    size,
+		policy:  PolicyBlock,
+		timeout: pubTimeout,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ch = make(chan interface{}, s.size)
+	return s
+}
+
+// send delivers the message according to the policy, returns false if the subscriber should be disconnected
+func (s *subscription) send(msg interface{}) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return true, nil
+	}
+
+	select {
+	case s.ch <- msg:
+		return true, nil
+	default:
+	}
+
+	policy := s.policy
+	if policy == PolicyDropOldest && cap(s.ch) == 0 {
+		policy = PolicyDropNewest
+	}
+	switch policy {
+	case PolicyDropNewest:
+		atomic.AddUint64(&s.dropped, 1)
+		return true, nil
+	case PolicyDropOldest:
+		for {
+			select {
+			case s.ch <- msg:
+				return true, nil
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	case PolicyDisconnect:
+		atomic.AddUint64(&s.dropped, 1)
+		return false, nil
+	}
+
+	var timeout <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case s.ch <- msg:
+		return true, nil
+	case <-s.done:
+		return true, nil
+	case <-timeout:
+		atomic.AddUint64(&s.dropped, 1)
+		return true, ErrPubsubTimeout
+	}
+}
+
+// close closes the channel, the blocked publishers are woken up first
+func (s *subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		s.closed = true
+		close(s.ch)
+		s.lock.Unlock()
+	})
+}
+
+func (s *subscription) droppedCount() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *subscription) stats() SubscriberStats {
+	return SubscriberStats{
+		Policy:  s.policy,
+		Size:    cap(s.ch),
+		Queued:  len(s.ch),
+		Dropped: s.droppedCount(),
+	}
+}
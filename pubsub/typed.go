@@ -0,0 +1,143 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+// Topic is a topic bound to the type of its messages, e.g.
+//
+//	var NodeEvents = pubsub.Topic[Event]("node/events")
+type Topic[T any] string
+
+// TypedPubsub is a type-safe view of a Pubsub, the messages are published and subscribed as T.
+// It shares the topics with the underlying Pubsub, so both APIs interoperate, the messages of other types
+// published by the underlying Pubsub are dropped by the typed subscriptions and counted by Dropped.
+type TypedPubsub[T any] struct {
+	pb   Pubsub
+	subs map[<-chan T]*typedSubscription[T]
+	lock sync.Mutex
+	log  *log.Logger
+}
+
+type typedSubscription[T any] struct {
+	raw        <-chan interface{}
+	ch         chan T
+	mismatched uint64
+	done       chan struct{}
+	dead       chan struct{}
+}
+
+// NewTypedPubsub creates a typed pubsub over the pubsub
+func NewTypedPubsub[T any](pb Pubsub) *TypedPubsub[T] {
+	return &TypedPubsub[T]{
+		pb:   pb,
+		subs: map[<-chan T]*typedSubscription[T]{},
+		log:  log.With(log.Any("pubsub", "typed")),
+	}
+}
+
+// Untyped returns the underlying pubsub
+func (p *TypedPubsub[T]) Untyped() Pubsub {
+	return p.pb
+}
+
+func (p *TypedPubsub[T]) Publish(topic Topic[T], msg T) error {
+	return p.pb.Publish(string(topic), msg)
+}
+
+// Subscribe subscribes the topic, see Pubsub.Subscribe.
+// The channel is closed when the subscription is removed by Unsubscribe, Close of the underlying pubsub,
+// or disconnected as a slow subscriber.
+func (p *TypedPubsub[T]) Subscribe(topic Topic[T], opts ...SubscribeOption) (<-chan T, error) {
+	raw, err := p.pb.Subscribe(string(topic), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p.typed(topic, raw), nil
+}
+
+// SubscribeContext subscribes the topic, the subscription is removed when the ctx is done, see Pubsub.SubscribeContext
+func (p *TypedPubsub[T]) SubscribeContext(ctx context.Context, topic Topic[T], opts ...SubscribeOption) (<-chan T, error) {
+	raw, err := p.pb.SubscribeContext(ctx, string(topic), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p.typed(topic, raw), nil
+}
+
+func (p *TypedPubsub[T]) typed(topic Topic[T], raw <-chan interface{}) <-chan T {
+	sub := &typedSubscription[T]{
+		raw:  raw,
+		ch:   make(chan T),
+		done: make(chan struct{}),
+		dead: make(chan struct{}),
+	}
+	p.lock.Lock()
+	p.subs[sub.ch] = sub
+	p.lock.Unlock()
+	go p.converting(string(topic), sub)
+	return sub.ch
+}
+
+func (p *TypedPubsub[T]) Unsubscribe(topic Topic[T], ch <-chan T) error {
+	p.lock.Lock()
+	sub, ok := p.subs[ch]
+	delete(p.subs, ch)
+	p.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	err := p.pb.Unsubscribe(string(topic), sub.raw)
+	close(sub.done)
+	<-sub.dead
+	return err
+}
+
+// Dropped returns the number of messages dropped by the subscription, including the messages of other types
+func (p *TypedPubsub[T]) Dropped(topic Topic[T], ch <-chan T) uint64 {
+	p.lock.Lock()
+	sub, ok := p.subs[ch]
+	p.lock.Unlock()
+	if !ok {
+		return 0
+	}
+	return p.pb.Dropped(string(topic), sub.raw) + atomic.LoadUint64(&sub.mismatched)
+}
+
+// converting forwards the messages of type T from the underlying subscription
+func (p *TypedPubsub[T]) converting(topic string, sub *typedSubscription[T]) {
+	defer close(sub.dead)
+	defer close(sub.ch)
+	for {
+		var msg interface{}
+		var ok bool
+		select {
+		case msg, ok = <-sub.raw:
+			if !ok {
+				// removed by the underlying pubsub
+				p.lock.Lock()
+				delete(p.subs, sub.ch)
+				p.lock.Unlock()
+				return
+			}
+		case <-sub.done:
+			return
+		}
+		v, ok := msg.(T)
+		if !ok {
+			atomic.AddUint64(&sub.mismatched, 1)
+			p.log.Warn("drop message of mismatched type", log.Any("topic", topic), log.Any("type", fmt.Sprintf("%T", msg)))
+			continue
+		}
+		select {
+		case sub.ch <- v:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+type nodeEvent struct {
+	Name string
+}
+
+const nodeEvents = Topic[nodeEvent]("node/events")
+
+func TestTypedPubsub(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ps := NewTypedPubsub[nodeEvent](pb)
+	assert.Equal(t, pb, ps.Untyped())
+	ch, err := ps.Subscribe(nodeEvents)
+	assert.NoError(t, err)
+	all, err := ps.Subscribe("node/+")
+	assert.NoError(t, err)
+
+	// interoperate with the untyped api
+	raw, err := pb.Subscribe(string(nodeEvents))
+	assert.NoError(t, err)
+	assert.NoError(t, ps.Publish(nodeEvents, nodeEvent{Name: "n1"}))
+	assert.Equal(t, nodeEvent{Name: "n1"}, <-raw)
+	assert.Equal(t, nodeEvent{Name: "n1"}, <-ch)
+	assert.Equal(t, nodeEvent{Name: "n1"}, <-all)
+
+	// the messages of other types are dropped
+	assert.NoError(t, pb.Publish(string(nodeEvents), "n2"))
+	assert.NoError(t, pb.Publish(string(nodeEvents), nodeEvent{Name: "n3"}))
+	assert.Equal(t, nodeEvent{Name: "n3"}, <-ch)
+	assert.Equal(t, nodeEvent{Name: "n3"}, <-all)
+	assert.Equal(t, uint64(1), ps.Dropped(nodeEvents, ch))
+	assert.Equal(t, uint64(1), ps.Dropped("node/+", all))
+
+	_, err = ps.Subscribe("node/#/events")
+	assert.Equal(t, ErrPubsubInvalidTopic, errors.Cause(err))
+
+	assert.NoError(t, ps.Unsubscribe(nodeEvents, ch))
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), ps.Dropped(nodeEvents, ch))
+	assert.NoError(t, ps.Unsubscribe(nodeEvents, ch))
+}
+
+type typedHandler struct {
+	wg       *sync.WaitGroup
+	lock     sync.Mutex
+	received []nodeEvent
+}
+
+func (h *typedHandler) OnMessage(msg nodeEvent) error {
+	h.lock.Lock()
+	h.received = append(h.received, msg)
+	h.lock.Unlock()
+	h.wg.Done()
+	return nil
+}
+
+func (h *typedHandler) OnTimeout() error {
+	return nil
+}
+
+func TestTypedProcessor(t *testing.T) {
+	pb, err := NewPubsub(10)
+	assert.NoError(t, err)
+	defer pb.Close()
+
+	ps := NewTypedPubsub[nodeEvent](pb)
+	ch, err := ps.Subscribe(nodeEvents)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	h := &typedHandler{wg: &wg}
+	p := NewTypedProcessor[nodeEvent](ch, time.Minute, h, WithConcurrency(2))
+	p.Start()
+	defer p.Close()
+
+	assert.NoError(t, ps.Publish(nodeEvents, nodeEvent{Name: "n1"}))
+	assert.NoError(t, ps.Publish(nodeEvents, nodeEvent{Name: "n2"}))
+	wg.Wait()
+	assert.ElementsMatch(t, []nodeEvent{{Name: "n1"}, {Name: "n2"}}, h.received)
+}
@@ -1,14 +1,16 @@
 package task
 
 import (
+	"context"
 	"time"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 )
 
 var (
-	SendMsgTimeout = errors.New("failed to send message")
-	GetMsgTimeout  = errors.New("failed to get message")
+	SendMsgTimeout  = errors.New("failed to send message")
+	GetMsgTimeout   = errors.New("failed to get message")
+	ErrBrokerClosed = errors.New("broker is closed")
 )
 
 type channelBroker struct {
@@ -39,6 +41,18 @@ func (b *channelBroker) GetMessage() (*BrokerMessage, error) {
 	}
 }
 
+func (b *channelBroker) ReceiveMessage(ctx context.Context) (*BrokerMessage, error) {
+	select {
+	case msg, ok := <-b.broker:
+		if !ok {
+			return nil, ErrBrokerClosed
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (b *channelBroker) Close() error {
 	close(b.broker)
 	return nil
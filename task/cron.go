@@ -0,0 +1,268 @@
+package task
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// Schedule decides when a periodic task runs
+type Schedule interface {
+	// Next returns the next run time after t, the zero time is returned if there is none
+	Next(t time.Time) time.Time
+}
+
+// everySchedule runs at the multiples of the interval
+type everySchedule struct {
+	interval time.Duration
+}
+
+// Every creates a schedule running every interval, which is at least one second.
+// The run times are aligned to the multiples of the interval, e.g. every hour runs at the top of the hour in UTC,
+// so the schedulers started at different times agree on them.
+func Every(interval time.Duration) Schedule {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return &everySchedule{interval: interval}
+}
+
+func (s *everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.interval).Add(s.interval)
+}
+
+// cronField the allowed values of a cron field as a bit set
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+type cronBounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteBounds = cronBounds{min: 0, max: 59}
+	hourBounds   = cronBounds{min: 0, max: 23}
+	domBounds    = cronBounds{min: 1, max: 31}
+	monthBounds  = cronBounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is also Sunday
+	dowBounds = cronBounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow cronField
+	// domStar and dowStar are set if the fields are exactly "*" or "?", a field with a step like "*/2" is restricted
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+// ParseCron parses the standard cron expression of 5 fields: minute, hour, day of month, month and day of week,
+// e.g. "*/15 9-18 * * mon-fri". The descriptors like "@daily" and "@every 1h30m" are also supported.
+// If both the day of month and the day of week are restricted, including by "*/n", the day matches either of them.
+// The expression is evaluated in the local time zone, unless it starts with "CRON_TZ=<zone>" or "TZ=<zone>",
+// e.g. "CRON_TZ=Asia/Shanghai 0 3 * * *".
+func ParseCron(spec string) (Schedule, error) {
+	return ParseCronInLocation(spec, time.Local)
+}
+
+// ParseCronInLocation parses the cron expression evaluated in the time zone, see ParseCron
+func ParseCronInLocation(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, errors.Errorf("invalid cron spec %q: missing fields", spec)
+		}
+		var err error
+		loc, err = time.LoadLocation(spec[strings.Index(spec, "=")+1 : i])
+		if err != nil {
+			return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+		}
+		return Every(d), nil
+	}
+	if v, ok := cronDescriptors[spec]; ok {
+		spec = v
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid cron spec %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &cronSchedule{loc: loc}
+	var err error
+	if s.minute, err = parseCronField(fields[0], minuteBounds); err != nil {
+		return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+	}
+	if s.hour, err = parseCronField(fields[1], hourBounds); err != nil {
+		return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+	}
+	if s.dom, err = parseCronField(fields[2], domBounds); err != nil {
+		return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+	}
+	if s.month, err = parseCronField(fields[3], monthBounds); err != nil {
+		return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+	}
+	if s.dow, err = parseCronField(fields[4], dowBounds); err != nil {
+		return nil, errors.Errorf("invalid cron spec %q: %s", spec, err.Error())
+	}
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+// parseCronField parses the comma separated list of "*", "?", "n", "a-b", "*/step", "a-b/step" and "a/step"
+func parseCronField(field string, b cronBounds) (cronField, error) {
+	var res cronField
+	for _, expr := range strings.Split(field, ",") {
+		rng, step := expr, 1
+		i := strings.Index(expr, "/")
+		if i >= 0 {
+			var err error
+			rng = expr[:i]
+			step, err = strconv.Atoi(expr[i+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step of %q", expr)
+			}
+		}
+
+		start, end := b.min, b.max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			var err error
+			if start, err = b.parse(parts[0]); err != nil {
+				return 0, err
+			}
+			if end, err = b.parse(parts[1]); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, errors.Errorf("invalid range %q", rng)
+			}
+		default:
+			var err error
+			if start, err = b.parse(rng); err != nil {
+				return 0, err
+			}
+			// "a/step" means from a to the max
+			if i < 0 {
+				end = start
+			}
+		}
+		for v := start; v <= end; v += step {
+			res |= 1 << uint(v)
+		}
+	}
+	return res, nil
+}
+
+func (b cronBounds) parse(s string) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid value %q", s)
+	}
+	if v < b.min || v > b.max {
+		return 0, errors.Errorf("value %d out of range [%d, %d]", v, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Next returns the next matching minute after t in the time zone of the schedule.
+// The times skipped by daylight saving time never match, and the repeated times may match twice.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	// the expression like "0 0 30 2 *" never matches
+	limit := t.Year() + 5
+
+WRAP:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for !s.month.has(int(t.Month())) {
+		t = midnight(t.Year(), t.Month()+1, 1, s.loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !s.dayMatches(t) {
+		t = midnight(t.Year(), t.Month(), t.Day()+1, s.loc)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for !s.hour.has(t.Hour()) {
+		// the absolute time is added, because the local time may be skipped or repeated
+		day := t.Day()
+		t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+		if t.Day() != day {
+			goto WRAP
+		}
+	}
+	for !s.minute.has(t.Minute()) {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	return t
+}
+
+// dayMatches follows the cron convention, the day matches either field if both of them are restricted.
+// Like robfig/cron and unlike Vixie cron, "*/n" restricts the field, e.g. "0 8 */2 * mon" runs on the odd days
+// and on Mondays, rather than on the odd days which are Mondays.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// midnight returns the start of the day, which is after midnight if midnight is skipped by daylight saving time
+func midnight(year int, month time.Month, day int, loc *time.Location) time.Time {
+	noon := time.Date(year, month, day, 12, 0, 0, 0, loc)
+	t := time.Date(noon.Year(), noon.Month(), noon.Day(), 0, 0, 0, 0, loc)
+	for t.Day() != noon.Day() {
+		t = t.Add(time.Hour)
+	}
+	return t
+}
@@ -0,0 +1,92 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+	from := time.Date(2024, 1, 31, 10, 30, 15, 0, time.UTC) // Wednesday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, 2, 1, 10, 30, 0, 0, time.UTC)},
+		{"0 9-18/3 * * *", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * mon-fri", time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2024, 2, 4, 8, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week matches
+		{"0 8 15 * sat", time.Date(2024, 2, 3, 8, 0, 0, 0, time.UTC)},
+		// the field with a step like "*/2" is restricted, so the days are still ORed
+		{"0 8 */2 * mon", time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)},
+		{"0 8 1 * */3", time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0,45 10 * * ?", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 1h", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		// 03:00 in Shanghai is 19:00 in UTC
+		{"CRON_TZ=Asia/Shanghai 0 3 * * *", time.Date(2024, 2, 1, 3, 0, 0, 0, shanghai)},
+		{"TZ=UTC 0 3 * * *", time.Date(2024, 2, 1, 3, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseCronInLocation(tt.spec, time.UTC)
+			require.NoError(t, err)
+			next := s.Next(from)
+			assert.True(t, tt.next.Equal(next), "expected %s, got %s", tt.next, next)
+		})
+	}
+
+	s, err := ParseCronInLocation("0 3 * * *", shanghai)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 1, 31, 19, 0, 0, 0, time.UTC).Equal(s.Next(from)))
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "CRON_TZ=Nowhere/City * * * * *", "@every x"} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCronDaylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	s, err := ParseCronInLocation("30 2 * * *", ny)
+	require.NoError(t, err)
+	// 02:30 does not exist on 2024-03-10
+	next := s.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, ny))
+	assert.True(t, time.Date(2024, 3, 11, 2, 30, 0, 0, ny).Equal(next), next.String())
+
+	s, err = ParseCronInLocation("0 12 * * *", ny)
+	require.NoError(t, err)
+	next = s.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, ny))
+	assert.Equal(t, 23*time.Hour, next.Sub(time.Date(2024, 3, 9, 12, 0, 0, 0, ny)))
+	assert.Equal(t, 12, next.Hour())
+
+	// midnight does not exist on 2024-09-08 in Santiago
+	santiago, err := time.LoadLocation("America/Santiago")
+	require.NoError(t, err)
+	s, err = ParseCronInLocation("0 * 8 9 *", santiago)
+	require.NoError(t, err)
+	next = s.Next(time.Date(2024, 9, 7, 22, 0, 0, 0, santiago))
+	assert.True(t, time.Date(2024, 9, 8, 1, 0, 0, 0, santiago).Equal(next), next.String())
+}
+
+func TestEvery(t *testing.T) {
+	s := Every(time.Minute)
+	from := time.Date(2024, 1, 31, 10, 30, 15, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 31, 10, 31, 0, 0, time.UTC), s.Next(from))
+	assert.Equal(t, time.Date(2024, 1, 31, 10, 32, 0, 0, time.UTC), s.Next(s.Next(from)))
+	assert.Equal(t, time.Second, Every(time.Millisecond).Next(from).Sub(from.Truncate(time.Second)))
+}
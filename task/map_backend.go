@@ -1,7 +1,9 @@
 package task
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/baetyl/baetyl-go/v2/errors"
 )
@@ -12,29 +14,101 @@ var (
 
 type mapBackend struct {
 	mapLock sync.RWMutex
-	cache   map[string]*ResultMessage
+	cache   map[string]*mapResult
+	ttl     time.Duration
+	purged  time.Time
+	// waiters the waiters notified when the results of the tasks are set
+	waiters map[string]*mapWaiter
 }
 
+// mapWaiter the channel shared by the waiters of a task, which is closed when the result is set
+type mapWaiter struct {
+	ch chan struct{}
+	// count the number of the waiters, the channel is removed when all of them give up
+	count int
+}
+
+type mapResult struct {
+	result *ResultMessage
+	expire time.Time
+}
+
+// NewMapBackend creates an in-memory backend, the results expire after DefaultResultExpires
 func NewMapBackend() TaskBackend {
+	return NewMapBackendWithTTL(DefaultResultExpires)
+}
+
+// NewMapBackendWithTTL creates an in-memory backend, the results can be read repeatedly until they expire after ttl
+func NewMapBackendWithTTL(ttl time.Duration) TaskBackend {
 	return &mapBackend{
-		cache: map[string]*ResultMessage{},
+		cache:   map[string]*mapResult{},
+		ttl:     ttl,
+		purged:  time.Now(),
+		waiters: map[string]*mapWaiter{},
 	}
 }
 
 func (m *mapBackend) GetResult(taskId string) (*ResultMessage, error) {
-	m.mapLock.Lock()
-	defer m.mapLock.Unlock()
-	result, ok := m.cache[taskId]
-	if !ok {
-		return nil, ErrResultNotFound
-	}
-	delete(m.cache, taskId)
-	return result, nil
+	m.mapLock.RLock()
+	defer m.mapLock.RUnlock()
+	return m.get(taskId)
 }
 
 func (m *mapBackend) SetResult(taskID string, result *ResultMessage) error {
 	m.mapLock.Lock()
 	defer m.mapLock.Unlock()
-	m.cache[taskID] = result
+	now := time.Now()
+	if now.Sub(m.purged) > m.ttl {
+		for id, r := range m.cache {
+			if now.After(r.expire) {
+				delete(m.cache, id)
+			}
+		}
+		m.purged = now
+	}
+	m.cache[taskID] = &mapResult{result: result, expire: now.Add(m.ttl)}
+	if w, ok := m.waiters[taskID]; ok {
+		close(w.ch)
+		delete(m.waiters, taskID)
+	}
 	return nil
 }
+
+func (m *mapBackend) WaitResult(ctx context.Context, taskID string) (*ResultMessage, error) {
+	for {
+		m.mapLock.Lock()
+		result, err := m.get(taskID)
+		if err == nil && result.Ready() {
+			m.mapLock.Unlock()
+			return result, nil
+		}
+		w, ok := m.waiters[taskID]
+		if !ok {
+			w = &mapWaiter{ch: make(chan struct{})}
+			m.waiters[taskID] = w
+		}
+		w.count++
+		m.mapLock.Unlock()
+
+		select {
+		case <-w.ch:
+		case <-ctx.Done():
+			m.mapLock.Lock()
+			// the waiter is removed if nobody else waits, so the waiters of the tasks never finished do not leak
+			if w.count--; w.count == 0 && m.waiters[taskID] == w {
+				delete(m.waiters, taskID)
+			}
+			m.mapLock.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// get returns the result if it is not expired, the lock must be held
+func (m *mapBackend) get(taskId string) (*ResultMessage, error) {
+	r, ok := m.cache[taskId]
+	if !ok || time.Now().After(r.expire) {
+		return nil, ErrResultNotFound
+	}
+	return r.result, nil
+}
@@ -1,15 +1,40 @@
 package task
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"time"
 )
 
+// the statuses of the tasks, which are the same as Celery
 const (
-	TaskSuccess = "success"
-	TaskFail    = "fail"
+	// TaskPending the task is waiting to run or unknown
+	TaskPending = "PENDING"
+	// TaskStarted the task is running
+	TaskStarted = "STARTED"
+	// TaskRetry the task failed and is waiting to be retried
+	TaskRetry = "RETRY"
+	// TaskSuccess the task succeeded
+	TaskSuccess = "SUCCESS"
+	// TaskFail the task failed
+	TaskFail = "FAILURE"
+)
+
+// the statuses of the earlier versions, which are still accepted when the results are decoded
+const (
+	legacyTaskSuccess = "success"
+	legacyTaskFail    = "fail"
+)
+
+const (
+	// ReasonError the task returned an error and it is not retried
+	ReasonError = "error"
+	// ReasonMaxRetries the task still failed after the max retries
+	ReasonMaxRetries = "max retries exceeded"
+	// ReasonExpired the task is expired before it is run
+	ReasonExpired = "expired"
 
 	RetryGap = 10 * time.Millisecond
 )
@@ -21,6 +46,13 @@ type TaskMessage struct {
 	Kwargs  map[string]interface{} `json:"kwargs"`
 	Retries int                    `json:"retries"`
 	Expires *time.Time             `json:"expires"`
+	// ETA the task is not run before the time
+	ETA *time.Time `json:"eta"`
+	// MaxRetries the failed task is retried at most MaxRetries times
+	MaxRetries int `json:"max_retries,omitempty"`
+	// RetryBackoff the delay of the first retry, which doubles for each retry until RetryBackoffMax
+	RetryBackoff    time.Duration `json:"retry_backoff,omitempty"`
+	RetryBackoffMax time.Duration `json:"retry_backoff_max,omitempty"`
 }
 
 type ResultMessage struct {
@@ -28,6 +60,51 @@ type ResultMessage struct {
 	Status    string      `json:"status"`
 	Traceback string      `json:"traceback"`
 	Result    interface{} `json:"result"`
+	// Attempts the number of times the task is run
+	Attempts int `json:"attempts"`
+	// Reason the reason why the task finally failed, see ReasonError, ReasonMaxRetries and ReasonExpired
+	Reason string `json:"reason,omitempty"`
+}
+
+// TaskOption the option of a task
+type TaskOption func(tm *TaskMessage)
+
+// WithMaxRetries retries the failed task at most n times
+func WithMaxRetries(n int) TaskOption {
+	return func(tm *TaskMessage) {
+		tm.MaxRetries = n
+	}
+}
+
+// WithRetryBackoff delays the retries exponentially, starting from min and doubling until max.
+// The failed task is retried immediately by default.
+func WithRetryBackoff(min, max time.Duration) TaskOption {
+	return func(tm *TaskMessage) {
+		tm.RetryBackoff = min
+		tm.RetryBackoffMax = max
+	}
+}
+
+// WithCountdown runs the task after the duration
+func WithCountdown(d time.Duration) TaskOption {
+	return func(tm *TaskMessage) {
+		eta := time.Now().Add(d)
+		tm.ETA = &eta
+	}
+}
+
+// WithETA runs the task at the time
+func WithETA(eta time.Time) TaskOption {
+	return func(tm *TaskMessage) {
+		tm.ETA = &eta
+	}
+}
+
+// WithExpires discards the task if it is not run before the time
+func WithExpires(expires time.Time) TaskOption {
+	return func(tm *TaskMessage) {
+		tm.Expires = &expires
+	}
 }
 
 type BrokerMessage struct {
@@ -51,6 +128,21 @@ func (tm *TaskMessage) Encode() (string, error) {
 	return encodedData, err
 }
 
+// retryDelay returns the delay of the next retry, which doubles for each retry
+func (tm *TaskMessage) retryDelay() time.Duration {
+	delay := tm.RetryBackoff
+	for i := 0; i < tm.Retries; i++ {
+		if tm.RetryBackoffMax > 0 && delay >= tm.RetryBackoffMax {
+			break
+		}
+		delay *= 2
+	}
+	if tm.RetryBackoffMax > 0 && delay > tm.RetryBackoffMax {
+		delay = tm.RetryBackoffMax
+	}
+	return delay
+}
+
 // Decode return taskMessage
 func (bm *BrokerMessage) Decode() (*TaskMessage, error) {
 	body, err := base64.StdEncoding.DecodeString(bm.Value)
@@ -65,29 +157,98 @@ func (bm *BrokerMessage) Decode() (*TaskMessage, error) {
 	return msg, nil
 }
 
-// Get result synchronize
+// UnmarshalJSON decodes the result, the legacy statuses are converted to TaskSuccess and TaskFail
+func (rm *ResultMessage) UnmarshalJSON(data []byte) error {
+	type plain ResultMessage
+	if err := json.Unmarshal(data, (*plain)(rm)); err != nil {
+		return err
+	}
+	rm.Status = normalizeStatus(rm.Status)
+	return nil
+}
+
+// Ready returns true if the task is finished, whether it succeeded or failed
+func (rm *ResultMessage) Ready() bool {
+	status := normalizeStatus(rm.Status)
+	return status == TaskSuccess || status == TaskFail
+}
+
+// normalizeStatus converts the legacy status to the Celery one
+func normalizeStatus(status string) string {
+	switch status {
+	case legacyTaskSuccess:
+		return TaskSuccess
+	case legacyTaskFail:
+		return TaskFail
+	}
+	return status
+}
+
+// Get waits for the result of the finished task until the timeout
 func (tr *TaskResult) Get(timeout time.Duration) (*ResultMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	result, err := tr.Wait(ctx)
+	if err == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timeout result for %s", tr.ID)
+	}
+	return result, err
+}
+
+// Wait waits for the result of the finished task until the ctx is done.
+// The backend is notified of the result if it implements WaitableTaskBackend, otherwise it is polled.
+func (tr *TaskResult) Wait(ctx context.Context) (*ResultMessage, error) {
+	if tr.result != nil {
+		return tr.result, nil
+	}
+	if backend, ok := tr.backend.(WaitableTaskBackend); ok {
+		result, err := backend.WaitResult(ctx, tr.ID)
+		if err != nil {
+			return nil, err
+		}
+		tr.result = result
+		return result, nil
+	}
+
 	ticker := time.NewTicker(RetryGap)
-	timeoutChan := time.After(timeout)
 	defer ticker.Stop()
 	for {
 		select {
-		case <-timeoutChan:
-			return nil, fmt.Errorf("timeout result for %s", tr.ID)
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		case <-ticker.C:
 			result, err := tr.AsyncGet()
-			if err == ErrResultNotFound {
+			if err == ErrResultNotFound || (err == nil && !result.Ready()) {
 				continue
 			}
-			return result, nil
+			return result, err
 		}
 	}
 }
 
-// AsyncGet result
+// AsyncGet returns the result of the task in any status, ErrResultNotFound is returned if the task is pending
 func (tr *TaskResult) AsyncGet() (*ResultMessage, error) {
 	if tr.result != nil {
 		return tr.result, nil
 	}
-	return tr.backend.GetResult(tr.ID)
+	result, err := tr.backend.GetResult(tr.ID)
+	if err != nil {
+		return nil, err
+	}
+	if result.Ready() {
+		tr.result = result
+	}
+	return result, nil
+}
+
+// Status returns the status of the task, TaskPending is returned if the backend has no record of the task
+func (tr *TaskResult) Status() (string, error) {
+	result, err := tr.AsyncGet()
+	if err == ErrResultNotFound {
+		return TaskPending, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.Status, nil
 }
@@ -17,12 +17,29 @@ func NewTaskProducer(broker TaskBroker, backend TaskBackend) TaskProducer {
 }
 
 func (p *taskProducer) AddTask(name string, args ...interface{}) (*TaskResult, error) {
+	return p.AddTaskWithOptions(name, args, make(map[string]interface{}))
+}
+
+func (p *taskProducer) AddTaskWithKey(name string, args map[string]interface{}) (*TaskResult, error) {
+	return p.AddTaskWithOptions(name, make([]interface{}, 0), args)
+}
+
+func (p *taskProducer) AddTaskWithOptions(name string, args []interface{}, kwargs map[string]interface{}, opts ...TaskOption) (*TaskResult, error) {
 	id, _ := uuid.NewUUID()
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	if kwargs == nil {
+		kwargs = make(map[string]interface{})
+	}
 	task := &TaskMessage{
 		ID:     id.String(),
 		Name:   name,
 		Args:   args,
-		Kwargs: make(map[string]interface{}),
+		Kwargs: kwargs,
+	}
+	for _, opt := range opts {
+		opt(task)
 	}
 	encodedMsg, err := task.Encode()
 	if err != nil {
@@ -34,21 +51,3 @@ func (p *taskProducer) AddTask(name string, args ...interface{}) (*TaskResult, e
 			Value: encodedMsg,
 		})
 }
-
-func (p *taskProducer) AddTaskWithKey(name string, args map[string]interface{}) (*TaskResult, error) {
-	id, _ := uuid.NewUUID()
-	task := &TaskMessage{
-		ID:     id.String(),
-		Name:   name,
-		Args:   make([]interface{}, 0),
-		Kwargs: args,
-	}
-	encodedMsg, err := task.Encode()
-	if err != nil {
-		return nil, err
-	}
-	return &TaskResult{ID: id.String(), backend: p.backend}, p.broker.SendMessage(&BrokerMessage{
-		ID:    id.String(),
-		Value: encodedMsg,
-	})
-}
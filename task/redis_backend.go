@@ -0,0 +1,166 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+const (
+	// ResultKeyPrefix the key prefix of the task results of Celery
+	ResultKeyPrefix = "celery-task-meta-"
+	// DefaultResultExpires the default expiration of the task results of Celery
+	DefaultResultExpires = 24 * time.Hour
+)
+
+// celeryResult the task result of the Celery Redis backend
+type celeryResult struct {
+	TaskID    string        `json:"task_id"`
+	Status    string        `json:"status"`
+	Result    interface{}   `json:"result"`
+	Traceback *string       `json:"traceback"`
+	Children  []interface{} `json:"children"`
+	DateDone  *string       `json:"date_done"`
+	Attempts  int           `json:"attempts,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+}
+
+type redisBackend struct {
+	client  *redis.Client
+	expires time.Duration
+}
+
+// NewRedisBackend creates a backend storing the task results as the Celery Redis backend,
+// the results expire after the duration, DefaultResultExpires is used if expires is zero.
+// The results can be read repeatedly until they expire, and they are also published to the channel
+// of the same key as Celery does, so WaitResult is notified without polling.
+func NewRedisBackend(client *redis.Client, expires time.Duration) TaskBackend {
+	if expires == 0 {
+		expires = DefaultResultExpires
+	}
+	return &redisBackend{
+		client:  client,
+		expires: expires,
+	}
+}
+
+func (b *redisBackend) GetResult(taskId string) (*ResultMessage, error) {
+	data, err := b.client.Get(context.TODO(), ResultKeyPrefix+taskId).Bytes()
+	if err == redis.Nil {
+		return nil, ErrResultNotFound
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return decodeCeleryResult(data)
+}
+
+func (b *redisBackend) SetResult(taskID string, result *ResultMessage) error {
+	res := &celeryResult{
+		TaskID:   taskID,
+		Status:   result.Status,
+		Result:   result.Result,
+		Children: []interface{}{},
+		Attempts: result.Attempts,
+		Reason:   result.Reason,
+	}
+	if result.Ready() {
+		done := time.Now().UTC().Format("2006-01-02T15:04:05.000000")
+		res.DateDone = &done
+	}
+	if result.Status == TaskFail || result.Status == TaskRetry {
+		res.Traceback = &result.Traceback
+		res.Result = map[string]interface{}{
+			"exc_type":    "Exception",
+			"exc_module":  "builtins",
+			"exc_message": []interface{}{result.Traceback},
+		}
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	key := ResultKeyPrefix + taskID
+	ctx := context.TODO()
+	_, err = b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, b.expires)
+		pipe.Publish(ctx, key, data)
+		return nil
+	})
+	return errors.Trace(err)
+}
+
+func (b *redisBackend) WaitResult(ctx context.Context, taskID string) (*ResultMessage, error) {
+	key := ResultKeyPrefix + taskID
+	sub := b.client.Subscribe(ctx, key)
+	defer sub.Close()
+	// make sure the subscription is created before reading the result, so no notification is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Trace(err)
+	}
+	result, err := b.GetResult(taskID)
+	if err == nil && result.Ready() {
+		return result, nil
+	}
+	if err != nil && err != ErrResultNotFound {
+		return nil, err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil, errors.Trace(redis.ErrClosed)
+			}
+			result, err = decodeCeleryResult([]byte(msg.Payload))
+			if err != nil {
+				return nil, err
+			}
+			if result.Ready() {
+				return result, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func decodeCeleryResult(data []byte) (*ResultMessage, error) {
+	var res celeryResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, errors.Trace(err)
+	}
+	res.Status = normalizeStatus(res.Status)
+	msg := &ResultMessage{
+		ID:       res.TaskID,
+		Status:   res.Status,
+		Result:   res.Result,
+		Attempts: res.Attempts,
+		Reason:   res.Reason,
+	}
+	if res.Status == TaskFail || res.Status == TaskRetry {
+		msg.Result = nil
+		if res.Traceback != nil {
+			msg.Traceback = *res.Traceback
+		}
+		// the exception of celery is like {"exc_type": "ValueError", "exc_message": ["..."]}
+		if exc, ok := res.Result.(map[string]interface{}); ok && msg.Traceback == "" {
+			if v, ok := exc["exc_message"].([]interface{}); ok && len(v) > 0 {
+				msg.Traceback, _ = v[0].(string)
+			}
+		}
+	}
+	if res.Status == TaskStarted {
+		// the result of a started celery task is the info of the worker
+		msg.Result = nil
+	}
+	return msg, nil
+}
@@ -0,0 +1,204 @@
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+const (
+	// DefaultQueue the default queue of Celery
+	DefaultQueue = "celery"
+	// redisPollTimeout the timeout of BRPOP, which decides how soon ReceiveMessage notices the ctx is done
+	redisPollTimeout = time.Second
+)
+
+// celeryNaiveTimeLayouts the layouts of the naive datetimes sent by Celery with enable_utc=False,
+// which are in the local time zone
+var celeryNaiveTimeLayouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"}
+
+// celeryMessage the message of the Celery Redis transport
+type celeryMessage struct {
+	Body            string                 `json:"body"`
+	Headers         map[string]interface{} `json:"headers"`
+	ContentType     string                 `json:"content-type"`
+	ContentEncoding string                 `json:"content-encoding"`
+	Properties      celeryProperties       `json:"properties"`
+}
+
+type celeryProperties struct {
+	BodyEncoding  string             `json:"body_encoding"`
+	CorrelationID string             `json:"correlation_id"`
+	ReplyTo       string             `json:"reply_to"`
+	DeliveryInfo  celeryDeliveryInfo `json:"delivery_info"`
+	DeliveryMode  int                `json:"delivery_mode"`
+	DeliveryTag   string             `json:"delivery_tag"`
+}
+
+type celeryDeliveryInfo struct {
+	Priority   int    `json:"priority"`
+	RoutingKey string `json:"routing_key"`
+	Exchange   string `json:"exchange"`
+}
+
+type redisBroker struct {
+	client *redis.Client
+	queue  string
+}
+
+// NewRedisBroker creates a broker on the Redis list of the queue, which speaks the Celery Redis transport.
+// The tasks are sent in the Celery message protocol version 1, and the tasks of both version 1 and 2 are received.
+// The queue of Celery is used if queue is empty.
+//
+// The delivery is at most once: a task is removed from the list when it is received and it is never acknowledged,
+// so the task is lost if the worker crashes before it finishes. The same applies to the tasks held in memory
+// until their ETA, which are only sent back to the list when the worker stops gracefully.
+func NewRedisBroker(client *redis.Client, queue string) TaskBroker {
+	if queue == "" {
+		queue = DefaultQueue
+	}
+	return &redisBroker{
+		client: client,
+		queue:  queue,
+	}
+}
+
+func (b *redisBroker) SendMessage(msg *BrokerMessage) error {
+	data, err := json.Marshal(&celeryMessage{
+		Body:            msg.Value,
+		Headers:         map[string]interface{}{},
+		ContentType:     "application/json",
+		ContentEncoding: "utf-8",
+		Properties: celeryProperties{
+			BodyEncoding:  "base64",
+			CorrelationID: msg.ID,
+			DeliveryInfo: celeryDeliveryInfo{
+				RoutingKey: b.queue,
+				Exchange:   b.queue,
+			},
+			DeliveryMode: 2,
+			DeliveryTag:  uuid.New().String(),
+		},
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(b.client.LPush(context.TODO(), b.queue, data).Err())
+}
+
+func (b *redisBroker) GetMessage() (*BrokerMessage, error) {
+	data, err := b.client.RPop(context.TODO(), b.queue).Bytes()
+	if err == redis.Nil {
+		return nil, GetMsgTimeout
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return b.decode(data)
+}
+
+func (b *redisBroker) decode(data []byte) (*BrokerMessage, error) {
+	var msg celeryMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return msg.brokerMessage()
+}
+
+// ReceiveMessage pops a message by BRPOP, the message is removed before the task runs, see NewRedisBroker
+func (b *redisBroker) ReceiveMessage(ctx context.Context) (*BrokerMessage, error) {
+	for {
+		res, err := b.client.BRPop(ctx, redisPollTimeout, b.queue).Result()
+		if err == nil {
+			// the result is the key and the value
+			return b.decode([]byte(res[1]))
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != redis.Nil {
+			return nil, errors.Trace(err)
+		}
+	}
+}
+
+// Close does nothing, the redis client is closed by its owner
+func (b *redisBroker) Close() error {
+	return nil
+}
+
+// brokerMessage converts the celery message into the broker message,
+// the task of the message protocol version 2 is converted into version 1
+func (m *celeryMessage) brokerMessage() (*BrokerMessage, error) {
+	body := []byte(m.Body)
+	if m.Properties.BodyEncoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(m.Body); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	id, _ := m.Headers["id"].(string)
+	name, _ := m.Headers["task"].(string)
+	if id == "" || name == "" {
+		// version 1, the body is the task message
+		tm := &TaskMessage{}
+		if err := json.Unmarshal(body, tm); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &BrokerMessage{ID: tm.ID, Value: base64.StdEncoding.EncodeToString(body)}, nil
+	}
+
+	// version 2, the body is [args, kwargs, embed] and the others are in the headers
+	var parts []json.RawMessage
+	if err := json.Unmarshal(body, &parts); err != nil {
+		return nil, errors.Trace(err)
+	}
+	tm := &TaskMessage{ID: id, Name: name, Args: []interface{}{}, Kwargs: map[string]interface{}{}}
+	if len(parts) > 0 {
+		if err := json.Unmarshal(parts[0], &tm.Args); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if len(parts) > 1 {
+		if err := json.Unmarshal(parts[1], &tm.Kwargs); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if retries, ok := m.Headers["retries"].(float64); ok {
+		tm.Retries = int(retries)
+	}
+	for key, field := range map[string]**time.Time{"expires": &tm.Expires, "eta": &tm.ETA} {
+		if v, ok := m.Headers[key].(string); ok && v != "" {
+			t, err := parseCeleryTime(v)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			*field = &t
+		}
+	}
+	value, err := tm.Encode()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &BrokerMessage{ID: id, Value: value}, nil
+}
+
+// parseCeleryTime parses the isoformat datetime of Celery, the naive one without offset is in the local time zone
+func parseCeleryTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range celeryNaiveTimeLayouts {
+		if naive, nerr := time.ParseInLocation(layout, v, time.Local); nerr == nil {
+			return naive, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,87 @@
+package task
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+// DefaultScheduleKey the key of the redis hash saving the last run times
+const DefaultScheduleKey = "baetyl-beat-schedule"
+
+// claimScript sets the last run time in milliseconds if it is after the current one
+var claimScript = redis.NewScript(`
+local last = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+if last >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// releaseScript restores the last run time in milliseconds if it is still the claimed one, it is removed if the previous one is 0
+var releaseScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+if ARGV[3] == '0' then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+else
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+type redisScheduleStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisScheduleStore creates a store saving the last run times in the redis hash of the key,
+// DefaultScheduleKey is used if key is empty. The runs are claimed atomically,
+// so the schedulers sharing the store never enqueue a run twice.
+func NewRedisScheduleStore(client *redis.Client, key string) ScheduleStore {
+	if key == "" {
+		key = DefaultScheduleKey
+	}
+	return &redisScheduleStore{
+		client: client,
+		key:    key,
+	}
+}
+
+func (s *redisScheduleStore) LastRun(name string) (time.Time, error) {
+	v, err := s.client.HGet(context.TODO(), s.key, name).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (s *redisScheduleStore) Claim(name string, t time.Time) (bool, error) {
+	n, err := claimScript.Run(context.TODO(), s.client, []string{s.key}, name, t.UnixMilli()).Int()
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return n == 1, nil
+}
+
+func (s *redisScheduleStore) Release(name string, t, prev time.Time) error {
+	var ms int64
+	if !prev.IsZero() {
+		ms = prev.UnixMilli()
+	}
+	err := releaseScript.Run(context.TODO(), s.client, []string{s.key}, name, strconv.FormatInt(t.UnixMilli(), 10), ms).Err()
+	return errors.Trace(err)
+}
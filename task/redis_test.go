@@ -0,0 +1,209 @@
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisClient(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client, mr
+}
+
+func TestRedisTask(t *testing.T) {
+	client, _ := newTestRedisClient(t)
+	broker := NewRedisBroker(client, "")
+	backend := NewRedisBackend(client, time.Minute)
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend)
+	worker.Register("Add", Add)
+	worker.Register("AddKey", &addInt{})
+	worker.StartWorker(context.Background())
+	defer worker.StopWorker()
+	defer broker.Close()
+
+	asyncResult1, err := producer.AddTask("Add", 1, 2)
+	assert.NoError(t, err)
+	asyncResult2, err := producer.AddTaskWithKey("AddKey", map[string]interface{}{"a": 1, "b": "2"})
+	assert.NoError(t, err)
+
+	result1, err := asyncResult1.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, result1.Status)
+	assert.Equal(t, float64(3), result1.Result)
+
+	// the results can be read repeatedly
+	result1, err = asyncResult1.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), result1.Result)
+
+	result2, err := asyncResult2.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, result2.Status)
+	assert.Equal(t, float64(3), result2.Result)
+}
+
+func TestRedisCeleryProtocol(t *testing.T) {
+	client, mr := newTestRedisClient(t)
+	broker := NewRedisBroker(client, "tasks")
+	backend := NewRedisBackend(client, 0)
+	producer := NewTaskProducer(broker, backend)
+
+	// the message sent by go
+	res, err := producer.AddTask("tasks.add", 1, 2)
+	require.NoError(t, err)
+	items, err := mr.List("tasks")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	var msg celeryMessage
+	require.NoError(t, json.Unmarshal([]byte(items[0]), &msg))
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, "base64", msg.Properties.BodyEncoding)
+	assert.Equal(t, res.ID, msg.Properties.CorrelationID)
+	assert.Equal(t, "tasks", msg.Properties.DeliveryInfo.RoutingKey)
+	body, err := base64.StdEncoding.DecodeString(msg.Body)
+	require.NoError(t, err)
+	var tm map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &tm))
+	assert.Equal(t, res.ID, tm["id"])
+	assert.Equal(t, "tasks.add", tm["task"])
+	assert.Equal(t, []interface{}{float64(1), float64(2)}, tm["args"])
+
+	bm, err := broker.GetMessage()
+	require.NoError(t, err)
+	assert.Equal(t, res.ID, bm.ID)
+	_, err = broker.GetMessage()
+	assert.Equal(t, GetMsgTimeout, err)
+
+	// the message of protocol version 2 sent by python
+	body, _ = json.Marshal([]interface{}{[]interface{}{3, 4}, map[string]interface{}{"c": "d"}, map[string]interface{}{"callbacks": nil}})
+	data, _ := json.Marshal(map[string]interface{}{
+		"body":             base64.StdEncoding.EncodeToString(body),
+		"content-encoding": "utf-8",
+		"content-type":     "application/json",
+		"headers": map[string]interface{}{
+			"lang":    "py",
+			"task":    "tasks.mul",
+			"id":      "6b9b3a1e-6c4b-4d4e-9e1a-1c5d2c4b7f00",
+			"retries": 1,
+			"expires": "2030-01-01T00:00:00+00:00",
+			// the naive datetime sent with enable_utc=False
+			"eta": "2030-01-02T03:04:05.123456",
+		},
+		"properties": map[string]interface{}{
+			"correlation_id": "6b9b3a1e-6c4b-4d4e-9e1a-1c5d2c4b7f00",
+			"body_encoding":  "base64",
+			"delivery_info":  map[string]interface{}{"exchange": "", "routing_key": "tasks"},
+			"delivery_mode":  2,
+			"delivery_tag":   "1c2e9a4b-3f5d-4e6a-8b7c-9d0e1f2a3b4c",
+		},
+	})
+	_, err = mr.Lpush("tasks", string(data))
+	require.NoError(t, err)
+	bm, err = broker.GetMessage()
+	require.NoError(t, err)
+	decoded, err := bm.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, "6b9b3a1e-6c4b-4d4e-9e1a-1c5d2c4b7f00", decoded.ID)
+	assert.Equal(t, "tasks.mul", decoded.Name)
+	assert.Equal(t, []interface{}{float64(3), float64(4)}, decoded.Args)
+	assert.Equal(t, map[string]interface{}{"c": "d"}, decoded.Kwargs)
+	assert.Equal(t, 1, decoded.Retries)
+	assert.Equal(t, 2030, decoded.Expires.Year())
+	assert.True(t, time.Date(2030, 1, 2, 3, 4, 5, 123456000, time.Local).Equal(*decoded.ETA))
+
+	// the result read by python
+	_, err = backend.GetResult(res.ID)
+	assert.Equal(t, ErrResultNotFound, err)
+	require.NoError(t, backend.SetResult(res.ID, &ResultMessage{ID: res.ID, Status: TaskSuccess, Result: 3}))
+	meta, err := mr.Get(ResultKeyPrefix + res.ID)
+	require.NoError(t, err)
+	var cr map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(meta), &cr))
+	assert.Equal(t, "SUCCESS", cr["status"])
+	assert.Equal(t, float64(3), cr["result"])
+	assert.Equal(t, res.ID, cr["task_id"])
+	assert.Nil(t, cr["traceback"])
+	assert.Equal(t, DefaultResultExpires, mr.TTL(ResultKeyPrefix+res.ID))
+
+	// the results written by python
+	mr.Set(ResultKeyPrefix+"t1", `{"status": "FAILURE", "result": {"exc_type": "ValueError", "exc_message": ["bad value"], "exc_module": "builtins"}, "traceback": "Traceback (most recent call last): ...", "children": [], "date_done": "2023-01-01T00:00:00.000000", "task_id": "t1"}`)
+	result, err := backend.GetResult("t1")
+	require.NoError(t, err)
+	assert.Equal(t, TaskFail, result.Status)
+	assert.Equal(t, "Traceback (most recent call last): ...", result.Traceback)
+	mr.Set(ResultKeyPrefix+"t2", `{"status": "STARTED", "result": null, "traceback": null, "children": [], "task_id": "t2"}`)
+	result, err = backend.GetResult("t2")
+	require.NoError(t, err)
+	assert.Equal(t, TaskStarted, result.Status)
+	assert.False(t, result.Ready())
+
+	require.NoError(t, backend.SetResult("t3", &ResultMessage{ID: "t3", Status: TaskFail, Traceback: "bad args"}))
+	result, err = backend.GetResult("t3")
+	require.NoError(t, err)
+	assert.Equal(t, &ResultMessage{ID: "t3", Status: TaskFail, Traceback: "bad args"}, result)
+
+	// the legacy status
+	mr.Set(ResultKeyPrefix+"t4", `{"status": "success", "result": 1, "task_id": "t4"}`)
+	result, err = backend.GetResult("t4")
+	require.NoError(t, err)
+	assert.Equal(t, TaskSuccess, result.Status)
+	assert.True(t, result.Ready())
+}
+
+func TestRedisResultWait(t *testing.T) {
+	client, mr := newTestRedisClient(t)
+	backend := NewRedisBackend(client, time.Minute).(WaitableTaskBackend)
+	res := make(chan *ResultMessage, 1)
+	go func() {
+		result, err := backend.WaitResult(context.Background(), "t1")
+		assert.NoError(t, err)
+		res <- result
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(mr.PubSubChannels(ResultKeyPrefix+"t1")) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, backend.SetResult("t1", &ResultMessage{ID: "t1", Status: TaskRetry, Traceback: "failed"}))
+	require.NoError(t, backend.SetResult("t1", &ResultMessage{ID: "t1", Status: TaskSuccess, Result: "ok"}))
+	select {
+	case result := <-res:
+		assert.Equal(t, TaskSuccess, result.Status)
+		assert.Equal(t, "ok", result.Result)
+	case <-time.After(time.Second):
+		t.Fatal("the waiter is not notified")
+	}
+
+	// the finished result is read without waiting
+	result, err := backend.WaitResult(context.Background(), "t1")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", result.Result)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = backend.WaitResult(ctx, "t2")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	mr.FastForward(time.Minute)
+	_, err = backend.GetResult("t1")
+	assert.Equal(t, ErrResultNotFound, err)
+}
+
+func TestParseCeleryTime(t *testing.T) {
+	for _, v := range []string{"2030-01-02T03:04:05+08:00", "2030-01-02T03:04:05", "2030-01-02 03:04:05.5"} {
+		_, err := parseCeleryTime(v)
+		assert.NoError(t, err, v)
+	}
+	_, err := parseCeleryTime("2030-01-02")
+	assert.Error(t, err)
+}
@@ -0,0 +1,302 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+	"github.com/baetyl/baetyl-go/v2/log"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule entry")
+)
+
+// scheduleRetryInterval the interval to retry the run failed to be enqueued
+const scheduleRetryInterval = time.Second
+
+// ScheduleEntry the task enqueued periodically
+type ScheduleEntry struct {
+	// Name the unique name of the entry, which is the key of the last run time, Task is used if empty
+	Name     string
+	Task     string
+	Args     []interface{}
+	Kwargs   map[string]interface{}
+	Schedule Schedule
+	// Options the options of every enqueued task, e.g. WithMaxRetries
+	Options []TaskOption
+}
+
+type scheduledEntry struct {
+	*ScheduleEntry
+	next    time.Time
+	planned bool
+}
+
+type taskScheduler struct {
+	producer TaskProducer
+	store    ScheduleStore
+	location *time.Location
+	entries  map[string]*scheduledEntry
+	// wake wakes up the scheduler to plan the added entries
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+	lock   sync.Mutex
+	log    *log.Logger
+}
+
+// SchedulerOption the option of a task scheduler
+type SchedulerOption func(s *taskScheduler)
+
+// WithLocation evaluates the cron expressions without time zone in loc, the local time zone is used by default
+func WithLocation(loc *time.Location) SchedulerOption {
+	return func(s *taskScheduler) {
+		if loc != nil {
+			s.location = loc
+		}
+	}
+}
+
+// NewTaskScheduler creates a scheduler enqueuing the tasks by the producer when they are due.
+// The last run times are saved in the store, an in-memory store is used if nil. The run missed
+// while the scheduler is down is enqueued once when it restarts, if the store survives the restart.
+func NewTaskScheduler(producer TaskProducer, store ScheduleStore, opts ...SchedulerOption) TaskScheduler {
+	if store == nil {
+		store = NewMapScheduleStore()
+	}
+	s := &taskScheduler{
+		producer: producer,
+		store:    store,
+		location: time.Local,
+		entries:  map[string]*scheduledEntry{},
+		wake:     make(chan struct{}, 1),
+		log:      log.L().With(log.Any("task", "scheduler")),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *taskScheduler) AddCron(name, spec string, args ...interface{}) error {
+	schedule, err := ParseCronInLocation(spec, s.location)
+	if err != nil {
+		return err
+	}
+	return s.Add(&ScheduleEntry{Task: name, Args: args, Schedule: schedule})
+}
+
+func (s *taskScheduler) AddInterval(name string, interval time.Duration, args ...interface{}) error {
+	return s.Add(&ScheduleEntry{Task: name, Args: args, Schedule: Every(interval)})
+}
+
+func (s *taskScheduler) Add(entry *ScheduleEntry) error {
+	if entry == nil || entry.Task == "" || entry.Schedule == nil {
+		return errors.Trace(ErrInvalidSchedule)
+	}
+	if entry.Name == "" {
+		entry.Name = entry.Task
+	}
+	s.lock.Lock()
+	s.entries[entry.Name] = &scheduledEntry{ScheduleEntry: entry}
+	s.lock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *taskScheduler) Remove(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, name)
+}
+
+// StartScheduler starts enqueuing the tasks until the ctx is done or StopScheduler is called
+func (s *taskScheduler) StartScheduler(ctx context.Context) {
+	var schedulerCtx context.Context
+	schedulerCtx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.running(schedulerCtx)
+}
+
+// StopScheduler stops the scheduler, and waits for the tasks being enqueued
+func (s *taskScheduler) StopScheduler() {
+	s.cancel()
+	<-s.done
+}
+
+func (s *taskScheduler) running(ctx context.Context) {
+	defer close(s.done)
+	// the entries added before start are planned by the first tick
+	select {
+	case <-s.wake:
+	default:
+	}
+	for {
+		now := time.Now()
+		next := s.tick(now)
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-s.wake:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// tick enqueues the due tasks, returns the next time to tick, the zero time is returned if nothing is scheduled
+func (s *taskScheduler) tick(now time.Time) time.Time {
+	s.lock.Lock()
+	var due []*scheduledEntry
+	for _, e := range s.entries {
+		if !e.planned {
+			s.plan(e, now)
+		}
+		if !e.next.IsZero() && !e.next.After(now) {
+			due = append(due, e)
+		}
+	}
+	s.lock.Unlock()
+
+	var failed []*scheduledEntry
+	for _, e := range due {
+		if !s.run(e) {
+			failed = append(failed, e)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var next time.Time
+	for _, e := range due {
+		if containsEntry(failed, e) {
+			// the run is retried later, e.next is kept in the past
+			continue
+		}
+		n := e.Schedule.Next(e.next)
+		// the runs missed while the scheduler is down are enqueued once
+		if !n.IsZero() && !n.After(now) {
+			n = e.Schedule.Next(now)
+		}
+		e.next = n
+	}
+	for _, e := range s.entries {
+		n := e.next
+		if !n.IsZero() && !n.After(now) {
+			n = now.Add(scheduleRetryInterval)
+		}
+		if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+func containsEntry(entries []*scheduledEntry, e *scheduledEntry) bool {
+	for _, v := range entries {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+// plan decides the first run of the entry by its last run time, the lock must be held
+func (s *taskScheduler) plan(e *scheduledEntry, now time.Time) {
+	e.planned = true
+	last, err := s.store.LastRun(e.Name)
+	if err != nil {
+		s.log.Error("failed to get last run time", log.Any("name", e.Name), log.Error(err))
+	}
+	if last.IsZero() {
+		e.next = e.Schedule.Next(now)
+		return
+	}
+	e.next = e.Schedule.Next(last)
+}
+
+// run claims and enqueues the due run, returns false if it should be retried
+func (s *taskScheduler) run(e *scheduledEntry) bool {
+	prev, err := s.store.LastRun(e.Name)
+	if err != nil {
+		s.log.Error("failed to get last run time", log.Any("name", e.Name), log.Error(err))
+		return false
+	}
+	ok, err := s.store.Claim(e.Name, e.next)
+	if err != nil {
+		s.log.Error("failed to claim scheduled run", log.Any("name", e.Name), log.Any("time", e.next), log.Error(err))
+		return false
+	}
+	if !ok {
+		s.log.Debug("scheduled run is claimed by another scheduler", log.Any("name", e.Name), log.Any("time", e.next))
+		return true
+	}
+	res, err := s.producer.AddTaskWithOptions(e.Task, e.Args, e.Kwargs, e.Options...)
+	if err != nil {
+		s.log.Error("failed to enqueue scheduled task", log.Any("name", e.Name), log.Any("time", e.next), log.Error(err))
+		// give up the claim, so the run is not recorded as done and is retried
+		if err := s.store.Release(e.Name, e.next, prev); err != nil {
+			s.log.Error("failed to release scheduled run", log.Any("name", e.Name), log.Any("time", e.next), log.Error(err))
+		}
+		return false
+	}
+	s.log.Debug("enqueue scheduled task", log.Any("name", e.Name), log.Any("id", res.ID), log.Any("time", e.next))
+	return true
+}
+
+type mapScheduleStore struct {
+	lastRuns map[string]time.Time
+	lock     sync.Mutex
+}
+
+// NewMapScheduleStore creates an in-memory store, which is only shared by the schedulers of the process
+// and does not survive restarts, see NewRedisScheduleStore
+func NewMapScheduleStore() ScheduleStore {
+	return &mapScheduleStore{lastRuns: map[string]time.Time{}}
+}
+
+func (m *mapScheduleStore) LastRun(name string) (time.Time, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lastRuns[name], nil
+}
+
+func (m *mapScheduleStore) Claim(name string, t time.Time) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !t.After(m.lastRuns[name]) {
+		return false, nil
+	}
+	m.lastRuns[name] = t
+	return true, nil
+}
+
+func (m *mapScheduleStore) Release(name string, t, prev time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.lastRuns[name].Equal(t) {
+		return nil
+	}
+	if prev.IsZero() {
+		delete(m.lastRuns, name)
+	} else {
+		m.lastRuns[name] = prev
+	}
+	return nil
+}
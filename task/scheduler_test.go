@@ -0,0 +1,213 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/baetyl/baetyl-go/v2/errors"
+)
+
+type recordingProducer struct {
+	TaskProducer
+	tasks []string
+	lock  sync.Mutex
+}
+
+func (p *recordingProducer) AddTaskWithOptions(name string, args []interface{}, kwargs map[string]interface{}, opts ...TaskOption) (*TaskResult, error) {
+	p.lock.Lock()
+	p.tasks = append(p.tasks, name)
+	p.lock.Unlock()
+	return p.TaskProducer.AddTaskWithOptions(name, args, kwargs, opts...)
+}
+
+// failingProducer fails to enqueue the first tasks
+type failingProducer struct {
+	recordingProducer
+	failures int
+}
+
+func (p *failingProducer) AddTaskWithOptions(name string, args []interface{}, kwargs map[string]interface{}, opts ...TaskOption) (*TaskResult, error) {
+	p.lock.Lock()
+	if p.failures > 0 {
+		p.failures--
+		p.lock.Unlock()
+		return nil, errors.New("broker is down")
+	}
+	p.lock.Unlock()
+	return p.recordingProducer.AddTaskWithOptions(name, args, kwargs, opts...)
+}
+
+func (p *recordingProducer) count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.tasks)
+}
+
+func TestScheduler(t *testing.T) {
+	broker := NewChannelBroker(10)
+	backend := NewMapBackend()
+	producer := &recordingProducer{TaskProducer: NewTaskProducer(broker, backend)}
+	worker := NewTaskWorker(broker, backend)
+	worker.Register("Add", Add)
+	worker.StartWorker(context.Background())
+	defer worker.StopWorker()
+
+	store := NewMapScheduleStore()
+	scheduler := NewTaskScheduler(producer, store)
+	assert.Error(t, scheduler.AddCron("Add", "* * *"))
+	assert.Error(t, scheduler.Add(&ScheduleEntry{Task: "Add"}))
+	require.NoError(t, scheduler.AddInterval("Add", time.Second, 1, 2))
+	require.NoError(t, scheduler.AddCron("never", "0 0 30 2 *"))
+	scheduler.StartScheduler(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return producer.count() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	scheduler.StopScheduler()
+	last, err := store.LastRun("Add")
+	assert.NoError(t, err)
+	assert.Equal(t, last, last.Truncate(time.Second))
+	assert.WithinDuration(t, time.Now(), last, time.Second)
+	never, err := store.LastRun("never")
+	assert.NoError(t, err)
+	assert.True(t, never.IsZero())
+
+	// no task is enqueued after the scheduler stops
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, 2, producer.count())
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	broker := NewChannelBroker(10)
+	producer := &recordingProducer{TaskProducer: NewTaskProducer(broker, NewMapBackend())}
+	store := NewMapScheduleStore()
+	// the scheduler was down for hours
+	ok, err := store.Claim("cleanup", time.Now().Add(-3*time.Hour).Truncate(time.Hour))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	scheduler := NewTaskScheduler(producer, store)
+	require.NoError(t, scheduler.AddCron("cleanup", "@hourly"))
+	scheduler.StartScheduler(context.Background())
+	defer scheduler.StopScheduler()
+
+	// the missed runs are enqueued once
+	assert.Eventually(t, func() bool {
+		return producer.count() == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, producer.count())
+	last, err := store.LastRun("cleanup")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(last) < 3*time.Hour)
+}
+
+func TestSchedulerDedupe(t *testing.T) {
+	client, _ := newTestRedisClient(t)
+	broker := NewRedisBroker(client, "")
+	producer := &recordingProducer{TaskProducer: NewTaskProducer(broker, NewRedisBackend(client, time.Minute))}
+	store := NewRedisScheduleStore(client, "")
+
+	// start just after a second, so two runs are due in the next two seconds
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(1100 * time.Millisecond)))
+	var schedulers []TaskScheduler
+	for i := 0; i < 3; i++ {
+		scheduler := NewTaskScheduler(producer, store, WithLocation(time.UTC))
+		require.NoError(t, scheduler.Add(&ScheduleEntry{
+			Name:     "rollup",
+			Task:     "Add",
+			Args:     []interface{}{1, 2},
+			Schedule: Every(time.Second),
+			Options:  []TaskOption{WithMaxRetries(1)},
+		}))
+		scheduler.StartScheduler(context.Background())
+		schedulers = append(schedulers, scheduler)
+	}
+	time.Sleep(2 * time.Second)
+	for _, scheduler := range schedulers {
+		scheduler.StopScheduler()
+	}
+	// every run is enqueued by one of the schedulers
+	assert.Equal(t, 2, producer.count())
+
+	last, err := store.LastRun("rollup")
+	assert.NoError(t, err)
+	ok, err := store.Claim("rollup", last)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = store.Claim("rollup", last.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestSchedulerEnqueueFailure(t *testing.T) {
+	broker := NewChannelBroker(10)
+	producer := &failingProducer{recordingProducer: recordingProducer{TaskProducer: NewTaskProducer(broker, NewMapBackend())}, failures: 1}
+	store := NewMapScheduleStore()
+	last := time.Now().Add(-3 * time.Hour).Truncate(time.Hour)
+	ok, err := store.Claim("cleanup", last)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	scheduler := NewTaskScheduler(producer, store)
+	require.NoError(t, scheduler.AddCron("cleanup", "@hourly"))
+	scheduler.StartScheduler(context.Background())
+	defer scheduler.StopScheduler()
+
+	// the claim is released when the task fails to be enqueued
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, producer.count())
+	prev, err := store.LastRun("cleanup")
+	assert.NoError(t, err)
+	assert.Equal(t, last, prev)
+
+	// the run is retried
+	assert.Eventually(t, func() bool {
+		return producer.count() == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	prev, err = store.LastRun("cleanup")
+	assert.NoError(t, err)
+	assert.True(t, prev.After(last))
+}
+
+func TestScheduleStoreRelease(t *testing.T) {
+	client, _ := newTestRedisClient(t)
+	for _, store := range []ScheduleStore{NewMapScheduleStore(), NewRedisScheduleStore(client, "")} {
+		t1 := time.Now().Truncate(time.Second)
+		t2 := t1.Add(time.Second)
+
+		// the first claim is released
+		ok, err := store.Claim("rollup", t1)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.NoError(t, store.Release("rollup", t1, time.Time{}))
+		last, err := store.LastRun("rollup")
+		assert.NoError(t, err)
+		assert.True(t, last.IsZero())
+
+		ok, err = store.Claim("rollup", t1)
+		require.NoError(t, err)
+		require.True(t, ok)
+		ok, err = store.Claim("rollup", t2)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.NoError(t, store.Release("rollup", t2, t1))
+		last, err = store.LastRun("rollup")
+		assert.NoError(t, err)
+		assert.True(t, t1.Equal(last))
+
+		// the run claimed later is not released
+		ok, err = store.Claim("rollup", t2)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.NoError(t, store.Release("rollup", t1, time.Time{}))
+		last, err = store.LastRun("rollup")
+		assert.NoError(t, err)
+		assert.True(t, t2.Equal(last))
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"io"
 	"reflect"
+	"time"
 )
 
 type TaskProducer interface {
 	AddTask(name string, args ...interface{}) (*TaskResult, error)
 	AddTaskWithKey(name string, args map[string]interface{}) (*TaskResult, error)
+	// AddTaskWithOptions adds a task with both args and kwargs, the options decide the retries and the schedule of the task
+	AddTaskWithOptions(name string, args []interface{}, kwargs map[string]interface{}, opts ...TaskOption) (*TaskResult, error)
 }
 
 type TaskBroker interface {
@@ -17,17 +20,58 @@ type TaskBroker interface {
 	io.Closer
 }
 
+// BlockingTaskBroker is a broker which can wait for messages
+type BlockingTaskBroker interface {
+	TaskBroker
+	// ReceiveMessage waits for a message until the ctx is done
+	ReceiveMessage(ctx context.Context) (*BrokerMessage, error)
+}
+
 type TaskWorker interface {
 	StartWorker(ctx context.Context)
 	StopWorker()
 	Register(name string, task interface{})
 }
 
+// TaskScheduler enqueues the tasks periodically by the producer, like Celery beat
+type TaskScheduler interface {
+	// AddCron schedules the task by the cron expression, see ParseCron
+	AddCron(name, spec string, args ...interface{}) error
+	// AddInterval schedules the task every interval
+	AddInterval(name string, interval time.Duration, args ...interface{}) error
+	// Add schedules the entry, the entry of the same name is replaced
+	Add(entry *ScheduleEntry) error
+	Remove(name string)
+	StartScheduler(ctx context.Context)
+	StopScheduler()
+}
+
+// ScheduleStore persists the last run times of the schedule entries,
+// a store shared by several schedulers makes sure every run is enqueued once
+type ScheduleStore interface {
+	// LastRun returns the last run time of the entry, the zero time is returned if it never runs
+	LastRun(name string) (time.Time, error)
+	// Claim sets the last run time of the entry to t if it is after the current one,
+	// returns false if the run is already claimed by another scheduler
+	Claim(name string, t time.Time) (bool, error)
+	// Release restores the last run time of the entry to prev if it is still t,
+	// which gives up the run claimed at t when the task fails to be enqueued
+	Release(name string, t, prev time.Time) error
+}
+
 type TaskBackend interface {
+	// GetResult returns the result of the task in any status, ErrResultNotFound is returned if there is none
 	GetResult(taskId string) (*ResultMessage, error)
 	SetResult(taskID string, result *ResultMessage) error
 }
 
+// WaitableTaskBackend is a backend which notifies the waiters when the results are set
+type WaitableTaskBackend interface {
+	TaskBackend
+	// WaitResult waits for the result of the finished task until the ctx is done
+	WaitResult(ctx context.Context, taskID string) (*ResultMessage, error)
+}
+
 type AsyncTask interface {
 	// ParseKwargs - define a method to parse kwargs
 	ParseKwargs(map[string]interface{}) error
@@ -2,7 +2,9 @@ package task
 
 import (
 	"context"
+	"encoding/json"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -151,6 +153,251 @@ func TestTaskMultiValues(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, resultMap.Result, map[string]string{"result": "test"})
 
-	_, err = asyncBlank.Get(time.Millisecond)
-	assert.NotNil(t, err)
+	// a task without return value succeeds with a nil result
+	resultBlank, err := asyncBlank.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, resultBlank.Status)
+	assert.Nil(t, resultBlank.Result)
+}
+
+type flakyTask struct {
+	failures int32
+	// calls is shared by the copies of the task
+	calls *int32
+}
+
+func (f *flakyTask) ParseKwargs(map[string]interface{}) error {
+	return nil
+}
+
+func (f *flakyTask) RunTask() (interface{}, error) {
+	calls := atomic.AddInt32(f.calls, 1)
+	if calls <= f.failures {
+		return nil, errors.New("flaky")
+	}
+	return int(calls), nil
+}
+
+func TestTaskRetry(t *testing.T) {
+	broker := NewChannelBroker(10)
+	backend := NewMapBackend()
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend)
+	worker.Register("flaky", &flakyTask{failures: 2, calls: new(int32)})
+	worker.Register("broken", &flakyTask{failures: 100, calls: new(int32)})
+	worker.StartWorker(context.Background())
+	defer worker.StopWorker()
+
+	start := time.Now()
+	flaky, err := producer.AddTaskWithOptions("flaky", nil, nil, WithMaxRetries(3), WithRetryBackoff(100*time.Millisecond, time.Second))
+	assert.NoError(t, err)
+	result, err := flaky.Get(3 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, result.Status)
+	assert.Equal(t, 3, result.Result)
+	assert.Equal(t, 3, result.Attempts)
+	assert.Empty(t, result.Reason)
+	// 100ms + 200ms of backoff
+	assert.True(t, time.Since(start) >= 300*time.Millisecond)
+
+	broken, err := producer.AddTaskWithOptions("broken", nil, nil, WithMaxRetries(2))
+	assert.NoError(t, err)
+	result, err = broken.Get(3 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskFail, result.Status)
+	assert.Equal(t, "flaky", result.Traceback)
+	assert.Equal(t, 3, result.Attempts)
+	assert.Equal(t, ReasonMaxRetries, result.Reason)
+
+	once, err := producer.AddTaskWithOptions("broken", nil, nil)
+	assert.NoError(t, err)
+	result, err = once.Get(3 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Attempts)
+	assert.Equal(t, ReasonError, result.Reason)
+
+	expired, err := producer.AddTaskWithOptions("Add", []interface{}{1, 2}, nil, WithExpires(time.Now().Add(-time.Second)))
+	assert.NoError(t, err)
+	result, err = expired.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskFail, result.Status)
+	assert.Equal(t, ReasonExpired, result.Reason)
+}
+
+func TestTaskCountdown(t *testing.T) {
+	broker := NewChannelBroker(10)
+	backend := NewMapBackend()
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend)
+	worker.Register("Add", Add)
+	worker.StartWorker(context.Background())
+
+	start := time.Now()
+	countdown, err := producer.AddTaskWithOptions("Add", []interface{}{1, 2}, nil, WithCountdown(500*time.Millisecond))
+	assert.NoError(t, err)
+	eta, err := producer.AddTaskWithOptions("Add", []interface{}{2, 3}, nil, WithETA(time.Now().Add(-time.Second)))
+	assert.NoError(t, err)
+
+	result, err := eta.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), result.Result)
+	_, err = countdown.AsyncGet()
+	assert.Equal(t, ErrResultNotFound, err)
+	result, err = countdown.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), result.Result)
+	assert.True(t, time.Since(start) >= 500*time.Millisecond)
+
+	// the delayed tasks are sent back to the broker when the worker stops
+	delayed, err := producer.AddTaskWithOptions("Add", []interface{}{1, 2}, nil, WithCountdown(time.Minute))
+	assert.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	worker.StopWorker()
+	time.Sleep(100 * time.Millisecond)
+	msg, err := broker.GetMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, delayed.ID, msg.ID)
+}
+
+func TestRetryDelay(t *testing.T) {
+	tm := &TaskMessage{}
+	assert.Equal(t, time.Duration(0), tm.retryDelay())
+	tm.RetryBackoff = time.Second
+	tm.RetryBackoffMax = 5 * time.Second
+	for i, d := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		tm.Retries = i
+		assert.Equal(t, d, tm.retryDelay())
+	}
+}
+
+func TestTaskStatus(t *testing.T) {
+	broker := NewChannelBroker(10)
+	backend := NewMapBackend()
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend)
+	release := make(chan struct{})
+	worker.Register("block", func() (int, error) {
+		<-release
+		return 1, nil
+	})
+
+	async, err := producer.AddTask("block")
+	assert.NoError(t, err)
+	status, err := async.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, TaskPending, status)
+
+	worker.StartWorker(context.Background())
+	defer worker.StopWorker()
+	assert.Eventually(t, func() bool {
+		status, err = async.Status()
+		return err == nil && status == TaskStarted
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = async.Wait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	result, err := async.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, result.Status)
+	assert.Equal(t, int64(1), result.Result)
+	status, err = async.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, status)
+}
+
+func TestMapBackendTTL(t *testing.T) {
+	backend := NewMapBackendWithTTL(100 * time.Millisecond)
+	assert.NoError(t, backend.SetResult("t1", &ResultMessage{ID: "t1", Status: TaskSuccess, Result: 1}))
+
+	// the result can be read repeatedly until it expires
+	for i := 0; i < 3; i++ {
+		result, err := backend.GetResult("t1")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, result.Result)
+	}
+	time.Sleep(150 * time.Millisecond)
+	_, err := backend.GetResult("t1")
+	assert.Equal(t, ErrResultNotFound, err)
+}
+
+func TestMapBackendWait(t *testing.T) {
+	backend := NewMapBackend().(WaitableTaskBackend)
+	res := make(chan *ResultMessage, 1)
+	go func() {
+		result, err := backend.WaitResult(context.Background(), "t1")
+		assert.NoError(t, err)
+		res <- result
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, backend.SetResult("t1", &ResultMessage{ID: "t1", Status: TaskStarted}))
+	select {
+	case <-res:
+		t.Fatal("the waiter should not be woken up by the started task")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, backend.SetResult("t1", &ResultMessage{ID: "t1", Status: TaskSuccess, Result: 2}))
+	select {
+	case result := <-res:
+		assert.Equal(t, 2, result.Result)
+	case <-time.After(time.Second):
+		t.Fatal("the waiter is not woken up")
+	}
+
+	// the finished result is returned immediately
+	result, err := backend.WaitResult(context.Background(), "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSuccess, result.Status)
+}
+
+func TestMapBackendWaitTimeout(t *testing.T) {
+	backend := NewMapBackend().(*mapBackend)
+
+	// the waiter is removed when the only waiter gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := backend.WaitResult(ctx, "t1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, backend.waiters)
+
+	// the waiter is kept while others are still waiting
+	res := make(chan *ResultMessage, 1)
+	go func() {
+		result, err := backend.WaitResult(context.Background(), "t2")
+		assert.NoError(t, err)
+		res <- result
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel2()
+	_, err = backend.WaitResult(ctx2, "t2")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.NoError(t, backend.SetResult("t2", &ResultMessage{ID: "t2", Status: TaskSuccess, Result: 2}))
+	select {
+	case result := <-res:
+		assert.Equal(t, 2, result.Result)
+	case <-time.After(time.Second):
+		t.Fatal("the waiter is not woken up")
+	}
+	assert.Empty(t, backend.waiters)
+}
+
+func TestResultMessageLegacyStatus(t *testing.T) {
+	var result ResultMessage
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"t1","status":"success","result":1}`), &result))
+	assert.Equal(t, TaskSuccess, result.Status)
+	assert.True(t, result.Ready())
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"t2","status":"fail","traceback":"bad"}`), &result))
+	assert.Equal(t, TaskFail, result.Status)
+	assert.Equal(t, "bad", result.Traceback)
+
+	// the results set by the backends of the earlier versions are finished
+	assert.True(t, (&ResultMessage{Status: "success"}).Ready())
+	assert.True(t, (&ResultMessage{Status: "fail"}).Ready())
+	assert.False(t, (&ResultMessage{Status: TaskStarted}).Ready())
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -25,60 +26,268 @@ type taskWorker struct {
 	registeredTasks map[string]interface{}
 	cancel          context.CancelFunc
 	rateLimitPeriod time.Duration
-	lock            sync.RWMutex
-	log             *log.Logger
+	concurrency     int
+	// jobs the tasks dispatched to the pool
+	jobs chan *delayedTask
+	// senders the goroutines sending to jobs, jobs is closed after all of them exit
+	senders sync.WaitGroup
+	stopped bool
+	done    chan struct{}
+	// delayed the tasks waiting for their ETA, which are sent back to the broker when the worker stops
+	delayed map[string]*delayedTask
+	lock    sync.RWMutex
+	log     *log.Logger
 }
 
-func NewTaskWorker(broker TaskBroker, backend TaskBackend) TaskWorker {
-	return &taskWorker{
+type delayedTask struct {
+	msg   *BrokerMessage
+	task  *TaskMessage
+	timer *time.Timer
+}
+
+// WorkerOption the option of a task worker
+type WorkerOption func(w *taskWorker)
+
+// WithConcurrency runs at most n tasks at the same time, the tasks are run one by one by default
+func WithConcurrency(n int) WorkerOption {
+	return func(w *taskWorker) {
+		if n > 0 {
+			w.concurrency = n
+		}
+	}
+}
+
+// NewTaskWorker creates a worker running the tasks received from the broker by a pool of goroutines.
+// The worker waits for the tasks if the broker implements BlockingTaskBroker, otherwise polls the broker.
+func NewTaskWorker(broker TaskBroker, backend TaskBackend, opts ...WorkerOption) TaskWorker {
+	w := &taskWorker{
 		broker:          broker,
 		backend:         backend,
 		registeredTasks: map[string]interface{}{},
 		rateLimitPeriod: RatePeriod,
+		concurrency:     1,
+		delayed:         map[string]*delayedTask{},
 		log:             log.L().With(log.Any("task", "worker")),
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
 }
 
+// StartWorker starts receiving and running tasks until the ctx is done or StopWorker is called
 func (w *taskWorker) StartWorker(ctx context.Context) {
 	var workerCtx context.Context
 	workerCtx, w.cancel = context.WithCancel(ctx)
+	w.jobs = make(chan *delayedTask)
+	w.done = make(chan struct{})
+	w.stopped = false
+
+	var workers sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range w.jobs {
+				w.execute(job.msg, job.task)
+			}
+		}()
+	}
+	w.senders.Add(1)
 	go func() {
-		ticker := time.NewTicker(w.rateLimitPeriod)
-		defer ticker.Stop()
-		for {
+		defer w.senders.Done()
+		w.receiving(workerCtx)
+	}()
+	go func() {
+		<-workerCtx.Done()
+		w.lock.Lock()
+		w.stopped = true
+		w.lock.Unlock()
+		w.requeueDelayed()
+		// drain the received tasks
+		w.senders.Wait()
+		close(w.jobs)
+		workers.Wait()
+		close(w.done)
+	}()
+}
+
+// StopWorker stops receiving tasks, and waits for the received tasks to finish
+func (w *taskWorker) StopWorker() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *taskWorker) receiving(ctx context.Context) {
+	blocking, ok := w.broker.(BlockingTaskBroker)
+	for {
+		var taskMsg *BrokerMessage
+		var err error
+		if ok {
+			taskMsg, err = blocking.ReceiveMessage(ctx)
+		} else {
+			taskMsg, err = w.broker.GetMessage()
+		}
+		if ctx.Err() != nil {
+			if taskMsg != nil {
+				// received before stopping
+				w.dispatch(ctx, taskMsg)
+			}
+			return
+		}
+		if err != nil || taskMsg == nil {
+			if err != nil && err != GetMsgTimeout {
+				w.log.Error("failed to receive message ", log.Error(err))
+			}
 			select {
-			case <-workerCtx.Done():
+			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				taskMsg, err := w.broker.GetMessage()
-				if err != nil || taskMsg == nil {
-					continue
-				}
-				decodedMsg, err := taskMsg.Decode()
-				if err != nil {
-					w.log.Error("failed to decode message ", log.Error(err))
-					continue
-				}
-				resultMsg, err := w.runTask(decodedMsg)
-				if err != nil {
-					w.log.Error("failed to run task ", log.Error(err))
-					continue
-				}
-				if resultMsg.Result != nil {
-					err = w.backend.SetResult(taskMsg.ID, resultMsg)
-					if err != nil {
-						w.log.Error("failed to set result ", log.Error(err))
-					}
-				}
+			case <-time.After(w.rateLimitPeriod):
 			}
+			continue
 		}
-	}()
+		w.dispatch(ctx, taskMsg)
+	}
 }
 
-func (w *taskWorker) StopWorker() {
-	w.cancel()
+// dispatch sends the task to the pool, or holds it until its ETA
+func (w *taskWorker) dispatch(ctx context.Context, taskMsg *BrokerMessage) {
+	decodedMsg, err := taskMsg.Decode()
+	if err != nil {
+		w.log.Error("failed to decode message ", log.Error(err))
+		return
+	}
+	if decodedMsg.ETA != nil && decodedMsg.ETA.After(time.Now()) {
+		if ctx.Err() != nil {
+			w.requeue(&delayedTask{msg: taskMsg, task: decodedMsg})
+			return
+		}
+		w.delay(taskMsg, decodedMsg)
+		return
+	}
+	w.jobs <- &delayedTask{msg: taskMsg, task: decodedMsg}
+}
+
+// execute runs the task, the failed task is sent back to the broker if it can be retried
+func (w *taskWorker) execute(taskMsg *BrokerMessage, decodedMsg *TaskMessage) {
+	if decodedMsg.Expires != nil && decodedMsg.Expires.UTC().Before(time.Now().UTC()) {
+		w.log.Warn("task is expired", log.Any("id", decodedMsg.ID), log.Any("expires", decodedMsg.Expires))
+		w.setResult(taskMsg.ID, &ResultMessage{
+			ID:        decodedMsg.ID,
+			Status:    TaskFail,
+			Traceback: fmt.Sprintf("task %s is expired on %s", decodedMsg.ID, decodedMsg.Expires),
+			Attempts:  decodedMsg.Retries,
+			Reason:    ReasonExpired,
+		})
+		return
+	}
+	w.setResult(taskMsg.ID, &ResultMessage{
+		ID:       decodedMsg.ID,
+		Status:   TaskStarted,
+		Attempts: decodedMsg.Retries + 1,
+	})
+	resultMsg, err := w.safeRunTask(decodedMsg)
+	if err != nil {
+		// the task can not run, e.g. it is not registered or the args are invalid, so it is not retried
+		w.log.Error("failed to run task ", log.Any("id", decodedMsg.ID), log.Error(err))
+		w.setResult(taskMsg.ID, &ResultMessage{
+			ID:        decodedMsg.ID,
+			Status:    TaskFail,
+			Traceback: err.Error(),
+			Attempts:  decodedMsg.Retries + 1,
+			Reason:    ReasonError,
+		})
+		return
+	}
+	resultMsg.Attempts = decodedMsg.Retries + 1
+	if resultMsg.Status == TaskFail {
+		if decodedMsg.Retries < decodedMsg.MaxRetries {
+			// the status is set before the task is sent back, so it never overwrites the next attempt
+			w.setResult(taskMsg.ID, &ResultMessage{
+				ID:        decodedMsg.ID,
+				Status:    TaskRetry,
+				Traceback: resultMsg.Traceback,
+				Attempts:  resultMsg.Attempts,
+			})
+			err = w.retry(decodedMsg)
+			if err == nil {
+				return
+			}
+			w.log.Error("failed to retry task ", log.Any("id", decodedMsg.ID), log.Error(err))
+		}
+		resultMsg.Reason = ReasonError
+		if decodedMsg.MaxRetries > 0 {
+			resultMsg.Reason = ReasonMaxRetries
+		}
+	}
+	w.setResult(taskMsg.ID, resultMsg)
+}
+
+func (w *taskWorker) setResult(id string, resultMsg *ResultMessage) {
+	if err := w.backend.SetResult(id, resultMsg); err != nil {
+		w.log.Error("failed to set result ", log.Error(err))
+	}
+}
+
+// retry sends the task back to the broker with the ETA of the next retry
+func (w *taskWorker) retry(msg *TaskMessage) error {
+	delay := msg.retryDelay()
+	msg.Retries++
+	msg.ETA = nil
+	if delay > 0 {
+		eta := time.Now().Add(delay)
+		msg.ETA = &eta
+	}
+	w.log.Warn("retry task", log.Any("id", msg.ID), log.Any("retries", msg.Retries), log.Any("delay", delay))
+	encodedMsg, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	return w.broker.SendMessage(&BrokerMessage{ID: msg.ID, Value: encodedMsg})
+}
+
+// delay holds the task until its ETA
+func (w *taskWorker) delay(taskMsg *BrokerMessage, decodedMsg *TaskMessage) {
+	d := &delayedTask{msg: taskMsg, task: decodedMsg}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.delayed[taskMsg.ID] = d
+	d.timer = time.AfterFunc(time.Until(*decodedMsg.ETA), func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		delete(w.delayed, taskMsg.ID)
+		if w.stopped {
+			w.requeue(d)
+			return
+		}
+		w.senders.Add(1)
+		go func() {
+			defer w.senders.Done()
+			w.jobs <- d
+		}()
+	})
+}
+
+// requeueDelayed sends the tasks waiting for their ETA back to the broker
+func (w *taskWorker) requeueDelayed() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for id, d := range w.delayed {
+		if d.timer.Stop() {
+			w.requeue(d)
+		}
+		delete(w.delayed, id)
+	}
 }
 
+func (w *taskWorker) requeue(d *delayedTask) {
+	if err := w.broker.SendMessage(d.msg); err != nil {
+		w.log.Error("failed to send delayed task back to broker", log.Any("id", d.msg.ID), log.Error(err))
+	}
+}
+
+// Register registers a func or an AsyncTask as the task of the name.
+// The AsyncTask is copied for each run if it is a pointer to struct, so the tasks can run concurrently.
 func (w *taskWorker) Register(name string, task interface{}) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
@@ -95,10 +304,23 @@ func (w *taskWorker) getTask(name string) interface{} {
 	return task
 }
 
+// safeRunTask runs the task, the panic of the task is recovered as a failure
+func (w *taskWorker) safeRunTask(msg *TaskMessage) (result *ResultMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			w.log.Error("task panicked", log.Any("id", msg.ID), log.Any("task", msg.Name), log.Any("panic", r), log.Any("stack", string(debug.Stack())))
+			result = &ResultMessage{
+				ID:        msg.ID,
+				Status:    TaskFail,
+				Traceback: fmt.Sprintf("task %s panicked: %v", msg.Name, r),
+			}
+			err = nil
+		}
+	}()
+	return w.runTask(msg)
+}
+
 func (w *taskWorker) runTask(msg *TaskMessage) (*ResultMessage, error) {
-	if msg.Expires != nil && msg.Expires.UTC().Before(time.Now().UTC()) {
-		return nil, fmt.Errorf("task %s is expired on %s", msg.ID, msg.Expires)
-	}
 	if msg.Args == nil {
 		return nil, fmt.Errorf("task %s is malformed - args cannot be nil", msg.ID)
 	}
@@ -109,6 +331,7 @@ func (w *taskWorker) runTask(msg *TaskMessage) (*ResultMessage, error) {
 	taskInterface, ok := task.(AsyncTask)
 	// If realize paresKwargs or RunTask function
 	if ok {
+		taskInterface = copyAsyncTask(taskInterface)
 		if err := taskInterface.ParseKwargs(msg.Kwargs); err != nil {
 			return nil, err
 		}
@@ -130,6 +353,20 @@ func (w *taskWorker) runTask(msg *TaskMessage) (*ResultMessage, error) {
 	return runTaskFunc(&taskFunc, msg)
 }
 
+// copyAsyncTask returns a shallow copy of the task if it is a pointer to struct
+func copyAsyncTask(task AsyncTask) AsyncTask {
+	v := reflect.ValueOf(task)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return task
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	if res, ok := c.Interface().(AsyncTask); ok {
+		return res
+	}
+	return task
+}
+
 func runTaskFunc(taskFunc *reflect.Value, msg *TaskMessage) (*ResultMessage, error) {
 	numArgs := taskFunc.Type().NumIn()
 	msgNumArgs := len(msg.Args)
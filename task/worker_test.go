@@ -0,0 +1,148 @@
+package task
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool(t *testing.T) {
+	broker := NewChannelBroker(100)
+	backend := NewMapBackend()
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend, WithConcurrency(4))
+
+	var running, peak int32
+	worker.Register("sleep", func(ms int) (int, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return ms, nil
+	})
+	worker.Register("Add", Add)
+	worker.StartWorker(context.Background())
+	defer worker.StopWorker()
+
+	// the tasks are not rate limited
+	start := time.Now()
+	var results []*TaskResult
+	for i := 0; i < 50; i++ {
+		res, err := producer.AddTask("Add", i, 1)
+		require.NoError(t, err)
+		results = append(results, res)
+	}
+	for i, res := range results {
+		r, err := res.Get(time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i+1), r.Result)
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	start = time.Now()
+	results = nil
+	for i := 0; i < 8; i++ {
+		res, err := producer.AddTask("sleep", 200)
+		require.NoError(t, err)
+		results = append(results, res)
+	}
+	for _, res := range results {
+		_, err := res.Get(2 * time.Second)
+		require.NoError(t, err)
+	}
+	assert.Less(t, int64(time.Since(start)), int64(800*time.Millisecond))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&peak))
+}
+
+func TestWorkerPanic(t *testing.T) {
+	broker := NewChannelBroker(10)
+	backend := NewMapBackend()
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend)
+	worker.Register("panic", func() (int, error) {
+		panic("boom")
+	})
+	worker.Register("Add", Add)
+	worker.StartWorker(context.Background())
+	defer worker.StopWorker()
+
+	res, err := producer.AddTask("panic")
+	require.NoError(t, err)
+	result, err := res.Get(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, TaskFail, result.Status)
+	assert.True(t, strings.Contains(result.Traceback, "boom"))
+
+	// the wrong args are recovered as well
+	res, err = producer.AddTask("Add", "a", "b")
+	require.NoError(t, err)
+	result, err = res.Get(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, TaskFail, result.Status)
+
+	// the task not registered fails instead of staying started
+	res, err = producer.AddTask("unknown")
+	require.NoError(t, err)
+	result, err = res.Get(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, TaskFail, result.Status)
+	assert.Equal(t, ReasonError, result.Reason)
+	assert.Contains(t, result.Traceback, "task unknown is not registered")
+
+	// the worker keeps working
+	res, err = producer.AddTask("Add", 1, 2)
+	require.NoError(t, err)
+	result, err = res.Get(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.Result)
+}
+
+func TestWorkerDrain(t *testing.T) {
+	client, _ := newTestRedisClient(t)
+	broker := NewRedisBroker(client, "")
+	backend := NewRedisBackend(client, time.Minute)
+	producer := NewTaskProducer(broker, backend)
+	worker := NewTaskWorker(broker, backend, WithConcurrency(2))
+	started := make(chan struct{}, 2)
+	worker.Register("slow", func(ms int) (int, error) {
+		started <- struct{}{}
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return ms, nil
+	})
+	worker.StartWorker(context.Background())
+
+	res1, err := producer.AddTask("slow", 300)
+	require.NoError(t, err)
+	res2, err := producer.AddTask("slow", 300)
+	require.NoError(t, err)
+	<-started
+	<-started
+
+	// the running tasks finish before StopWorker returns
+	worker.StopWorker()
+	for _, res := range []*TaskResult{res1, res2} {
+		result, err := res.AsyncGet()
+		require.NoError(t, err)
+		assert.Equal(t, float64(300), result.Result)
+	}
+
+	// no more tasks are received
+	res3, err := producer.AddTask("slow", 1)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = res3.AsyncGet()
+	assert.Equal(t, ErrResultNotFound, err)
+	msg, err := broker.GetMessage()
+	require.NoError(t, err)
+	assert.Equal(t, res3.ID, msg.ID)
+}